@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/whywaita/keex/pkg/detector"
+	"github.com/whywaita/keex/pkg/differ"
+	"github.com/whywaita/keex/pkg/dotenv"
+	"github.com/whywaita/keex/pkg/extractor"
+	"github.com/whywaita/keex/pkg/fetcher"
+	"github.com/whywaita/keex/pkg/resolver"
+)
+
+type diffOptions struct {
+	extractOptions
+	output string
+	salt   string
+}
+
+func newDiffCmd() *cobra.Command {
+	opts := &diffOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "diff A B",
+		Short: "Compare the environments of two manifests, clusters or env files",
+		Long: `Compare the environment variables of two sources and list the added, removed
+and changed variables. A source is one of
+
+  cluster:CONTEXT/NAMESPACE/KIND/NAME  a live workload; CONTEXT and NAMESPACE
+                                       may be empty for the current ones
+  dotenv:FILE                          a .env file; files named .env or *.env
+                                       are read as env files without the prefix
+  FILE                                 a manifest, "-" for stdin
+
+Secret values are compared by salted SHA-256 fingerprints and never printed.
+keex exits with status 0 when the environments are the same, 1 when they
+differ and 2 on errors.`,
+		Args:          cobra.ExactArgs(2),
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			err := runDiff(opts, args[0], args[1])
+			var exitErr *exitCodeError
+			if err != nil && !errors.As(err, &exitErr) {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				return &exitCodeError{code: 2}
+			}
+			return err
+		},
+	}
+
+	addResolveFlags(cmd, &opts.extractOptions)
+	cmd.Flags().StringVarP(&opts.output, "output", "o", "unified", "Output format: "+strings.Join(differ.Outputs, "|"))
+	cmd.Flags().StringVar(&opts.salt, "salt", "", "Salt for secret fingerprints, to compare them across runs (default: random)")
+
+	return cmd
+}
+
+func runDiff(opts *diffOptions, from, to string) error {
+	// Fail on a bad output format before reaching out to clusters
+	if _, err := differ.Format(nil, opts.output, from, to); err != nil {
+		return err
+	}
+
+	salt := opts.salt
+	if salt == "" {
+		random := make([]byte, 16)
+		if _, err := rand.Read(random); err != nil {
+			return fmt.Errorf("failed to generate salt: %w", err)
+		}
+		salt = hex.EncodeToString(random)
+	}
+
+	fromVars, err := loadDiffSource(&opts.extractOptions, from)
+	if err != nil {
+		return fmt.Errorf("%s: %w", from, err)
+	}
+	toVars, err := loadDiffSource(&opts.extractOptions, to)
+	if err != nil {
+		return fmt.Errorf("%s: %w", to, err)
+	}
+
+	changes := differ.Diff(fromVars, toVars, differ.Options{Salt: salt})
+	output, err := differ.Format(changes, opts.output, from, to)
+	if err != nil {
+		return err
+	}
+	if output != "" {
+		fmt.Println(output)
+	}
+
+	if len(changes) > 0 {
+		return &exitCodeError{code: 1}
+	}
+	return nil
+}
+
+// loadDiffSource returns the resolved environment of one side of a diff. Like
+// keex run, it compares the environment of one container and leaves out init
+// containers when the workload has others.
+func loadDiffSource(opts *extractOptions, source string) ([]extractor.EnvVar, error) {
+	var envVars []extractor.EnvVar
+	switch {
+	case strings.HasPrefix(source, "cluster:"):
+		loaded, err := loadClusterEnvVars(opts, strings.TrimPrefix(source, "cluster:"))
+		if err != nil {
+			return nil, err
+		}
+		envVars = loaded
+	case strings.HasPrefix(source, "dotenv:") || isDotenvFile(source):
+		loaded, err := loadDotenv(strings.TrimPrefix(source, "dotenv:"))
+		if err != nil {
+			return nil, err
+		}
+		envVars = loaded
+	default:
+		manifestOpts := *opts
+		manifestOpts.file = source
+		loaded, err := loadEnvVars(&manifestOpts)
+		if err != nil {
+			return nil, err
+		}
+		envVars = loaded.envVars
+	}
+
+	return singleContainerEnvVars(envVars)
+}
+
+// isDotenvFile reports whether the file is named like an env file
+func isDotenvFile(path string) bool {
+	base := filepath.Base(path)
+	return base == ".env" || strings.HasPrefix(base, ".env.") || strings.HasSuffix(base, ".env")
+}
+
+// loadDotenv reads an env file. Its values are inline, so only the values
+// that look like secrets are compared by fingerprint.
+func loadDotenv(path string) ([]extractor.EnvVar, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to close file: %v\n", err)
+		}
+	}()
+
+	envVars, err := dotenv.Parse(file)
+	if err != nil {
+		return nil, err
+	}
+	return detector.Mark(envVars), nil
+}
+
+// loadClusterEnvVars reads the workload CONTEXT/NAMESPACE/KIND/NAME from the
+// cluster and resolves its refs there
+func loadClusterEnvVars(opts *extractOptions, ref string) ([]extractor.EnvVar, error) {
+	parts := strings.Split(ref, "/")
+	if len(parts) != 4 || parts[2] == "" || parts[3] == "" {
+		return nil, fmt.Errorf("invalid cluster source, expected cluster:CONTEXT/NAMESPACE/KIND/NAME")
+	}
+	kubeContext, namespace, kind, name := parts[0], parts[1], parts[2], parts[3]
+
+	clientset, defaultNamespace, err := resolver.NewClientset(kubeContext)
+	if err != nil {
+		return nil, err
+	}
+	if namespace == "" {
+		namespace = defaultNamespace
+	}
+
+	ctx := context.Background()
+	workload, selector, err := fetcher.Fetch(ctx, clientset, namespace, kind, name)
+	if err != nil {
+		return nil, err
+	}
+
+	envVars := extractor.ExtractFromPodSpec(&workload.Pod.Spec, opts.container)
+	envVars = extractor.WithResource(envVars, workload.Kind, namespace, name)
+	envVars = detector.Mark(envVars)
+
+	// Resolve fieldRef values from a running pod when there is one
+	pod := workload.Pod
+	if selector != nil {
+		if livePod, err := fetcher.FindLivePod(ctx, clientset, namespace, selector); err == nil && livePod != nil {
+			pod = livePod
+		}
+	}
+	envVars = extractor.ResolveFieldRefs(envVars, pod)
+
+	return resolveEnvVars(opts, resolver.NewFromClientset(clientset, namespace), envVars)
+}
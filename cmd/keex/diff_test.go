@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const diffManifest = `apiVersion: v1
+kind: Pod
+metadata:
+  name: api
+spec:
+  initContainers:
+  - name: migrate
+    image: migrate
+    env:
+    - name: MIGRATE
+      value: "1"
+  containers:
+  - name: app
+    image: app
+    env:
+    - name: GREETING
+      value: hello
+`
+
+func TestLoadDiffSource(t *testing.T) {
+	// Resolve offline
+	t.Setenv("KUBECONFIG", filepath.Join(t.TempDir(), "missing"))
+	manifest := filepath.Join(t.TempDir(), "pod.yaml")
+	if err := os.WriteFile(manifest, []byte(diffManifest), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	envVars, err := loadDiffSource(&extractOptions{}, manifest)
+	if err != nil {
+		t.Fatalf("loadDiffSource() error = %v", err)
+	}
+	if len(envVars) != 1 || envVars[0].Name != "GREETING" {
+		t.Errorf("loadDiffSource() = %+v, want only the app container's GREETING", envVars)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/whywaita/keex/pkg/detector"
+	"github.com/whywaita/keex/pkg/drift"
+	"github.com/whywaita/keex/pkg/extractor"
+	"github.com/whywaita/keex/pkg/fetcher"
+	"github.com/whywaita/keex/pkg/resolver"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+type driftOptions struct {
+	extractOptions
+	output string
+	salt   string
+}
+
+func newDriftCmd() *cobra.Command {
+	opts := &driftOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "drift -f FILE",
+		Short: "Compare a manifest with the workload running in the cluster",
+		Long: `Compare the workload of a manifest with the same-named workload in the
+cluster. Differences are reported in three layers:
+
+  spec     env and envFrom entries that differ between the manifest and the
+           live workload
+  value    resolved values that differ although the entries are the same,
+           because a referenced Secret or ConfigMap differs from the one in
+           the manifest
+  rollout  referenced Secrets and ConfigMaps that were modified after the
+           running pod started, so the pod still uses the old values
+
+The manifest side resolves refs from the manifest first, then from the
+cluster. Secret values are compared by salted SHA-256 fingerprints and never
+printed. keex exits with status 0 when there is no drift, 1 when there is
+and 2 on errors.`,
+		Args:          cobra.NoArgs,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			err := runDrift(opts)
+			var exitErr *exitCodeError
+			if err != nil && !errors.As(err, &exitErr) {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				return &exitCodeError{code: 2}
+			}
+			return err
+		},
+	}
+
+	addSourceFlags(cmd, &opts.extractOptions)
+	cmd.Flags().StringVarP(&opts.output, "output", "o", "table", "Output format: "+strings.Join(drift.Outputs, "|"))
+	cmd.Flags().StringVar(&opts.salt, "salt", "", "Salt for secret fingerprints, to compare them across runs (default: random)")
+
+	return cmd
+}
+
+func runDrift(opts *driftOptions) error {
+	// Fail on a bad output format before reaching out to the cluster
+	if _, err := drift.Format(nil, opts.output, "", ""); err != nil {
+		return err
+	}
+
+	salt := opts.salt
+	if salt == "" {
+		random := make([]byte, 16)
+		if _, err := rand.Read(random); err != nil {
+			return fmt.Errorf("failed to generate salt: %w", err)
+		}
+		salt = hex.EncodeToString(random)
+	}
+	driftOpts := drift.Options{Salt: salt}
+
+	result, err := readManifest(&opts.extractOptions)
+	if err != nil {
+		return err
+	}
+	workload, err := selectWorkload(result)
+	if err != nil {
+		return err
+	}
+
+	clientset, namespace, err := resolver.NewClientset(opts.context)
+	if err != nil {
+		return err
+	}
+	switch {
+	case opts.namespace != "":
+		namespace = opts.namespace
+	case workload.Namespace != "":
+		namespace = workload.Namespace
+	}
+
+	drifts, liveName, err := findDrifts(context.Background(), opts, driftOpts, result, workload, clientset, namespace)
+	if err != nil {
+		return err
+	}
+
+	output, err := drift.Format(drifts, opts.output, opts.file, liveName)
+	if err != nil {
+		return err
+	}
+	if output != "" {
+		fmt.Println(output)
+	}
+
+	if len(drifts) > 0 {
+		fmt.Fprintf(os.Stderr, "Found %d drifts between %s and %s\n", len(drifts), opts.file, liveName)
+		return &exitCodeError{code: 1}
+	}
+	fmt.Fprintf(os.Stderr, "No drift between %s and %s\n", opts.file, liveName)
+	return nil
+}
+
+// findDrifts compares the workload of the manifest with the same-named
+// workload in namespace and returns the drifts and the name of the live
+// workload
+func findDrifts(ctx context.Context, opts *driftOptions, driftOpts drift.Options, result *extractor.Result, workload extractor.Workload, clientset kubernetes.Interface, namespace string) ([]drift.Drift, string, error) {
+	live, selector, err := fetcher.Fetch(ctx, clientset, namespace, workload.Kind, workload.Name)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Both sides resolve fieldRef values from the same pod, a running one when
+	// there is one, so that they only differ where the specs do
+	pod := live.Pod
+	var livePod *corev1.Pod
+	if selector != nil {
+		if found, err := fetcher.FindLivePod(ctx, clientset, namespace, selector); err == nil && found != nil {
+			livePod = found
+			pod = found
+		}
+	} else if live.Kind == "Pod" {
+		livePod = live.Pod
+	}
+
+	drifts := drift.CompareSpecs(&workload.Pod.Spec, &live.Pod.Spec, driftOpts)
+
+	// The manifest side resolves refs from the manifest first, the live side
+	// only from the cluster
+	manifestRes := resolver.NewFromClientset(clientset, namespace)
+	manifestRes.AddObjects(result.Secrets, result.ConfigMaps)
+	manifestVars, err := workloadResolvedEnvVars(opts, manifestRes, workload, namespace, pod)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", opts.file, err)
+	}
+	liveVars, err := workloadResolvedEnvVars(opts, resolver.NewFromClientset(clientset, namespace), live, namespace, pod)
+	if err != nil {
+		return nil, "", fmt.Errorf("cluster: %w", err)
+	}
+	drifts = append(drifts, drift.CompareValues(manifestVars, liveVars, drifts, driftOpts)...)
+
+	objects := referencedObjects(ctx, clientset, namespace, &live.Pod.Spec)
+	drifts = append(drifts, drift.CompareRollout(livePod, objects)...)
+
+	if opts.container != "" {
+		drifts = containerDrifts(drifts, opts.container)
+	}
+
+	return drifts, namespace + "/" + live.Kind + "/" + live.Name, nil
+}
+
+// workloadResolvedEnvVars extracts the env vars of the workload's pod spec
+// and resolves them with res, taking fieldRef values from pod
+func workloadResolvedEnvVars(opts *driftOptions, res *resolver.Resolver, workload extractor.Workload, namespace string, pod *corev1.Pod) ([]extractor.EnvVar, error) {
+	envVars := extractor.ExtractFromPodSpec(&workload.Pod.Spec, opts.container)
+	envVars = extractor.WithResource(envVars, workload.Kind, namespace, workload.Name)
+	envVars = detector.Mark(envVars)
+	envVars = extractor.ResolveFieldRefs(envVars, pod)
+	return resolveEnvVars(&opts.extractOptions, res, envVars)
+}
+
+// referencedObjects reads the Secrets and ConfigMaps the pod spec refers to
+// and when they were last modified. Missing objects are skipped, resolving
+// already reports them.
+func referencedObjects(ctx context.Context, clientset kubernetes.Interface, namespace string, spec *corev1.PodSpec) []drift.Object {
+	var objects []drift.Object
+	for _, object := range drift.ReferencedObjects(spec) {
+		var meta metav1.Object
+		var err error
+		switch object.Kind {
+		case "secret":
+			meta, err = clientset.CoreV1().Secrets(namespace).Get(ctx, object.Name, metav1.GetOptions{})
+		case "configMap":
+			meta, err = clientset.CoreV1().ConfigMaps(namespace).Get(ctx, object.Name, metav1.GetOptions{})
+		}
+		if err != nil {
+			continue
+		}
+		object.Modified = drift.LastModified(meta)
+		objects = append(objects, object)
+	}
+	return objects
+}
+
+// containerDrifts keeps the drifts of the given container and those that
+// are not tied to a container
+func containerDrifts(drifts []drift.Drift, container string) []drift.Drift {
+	var filtered []drift.Drift
+	for _, d := range drifts {
+		if d.Container == "" || d.Container == container {
+			filtered = append(filtered, d)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/whywaita/keex/pkg/differ"
+	"github.com/whywaita/keex/pkg/drift"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestFindDriftsWithoutEnv(t *testing.T) {
+	const manifest = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: api
+  namespace: prod
+spec:
+  template:
+    spec:
+      containers:
+      - name: app
+        image: app:1.0
+`
+	opts := &driftOptions{extractOptions: extractOptions{file: filepath.Join(t.TempDir(), "deployment.yaml")}}
+	if err := os.WriteFile(opts.file, []byte(manifest), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	result, err := readManifest(&opts.extractOptions)
+	if err != nil {
+		t.Fatalf("readManifest() error = %v", err)
+	}
+	workload, err := selectWorkload(result)
+	if err != nil {
+		t.Fatalf("selectWorkload() error = %v", err)
+	}
+
+	// The live workload gained an env var since the manifest was written
+	clientset := fake.NewSimpleClientset(&appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "prod"},
+		Spec: appsv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{{
+				Name:  "app",
+				Image: "app:1.0",
+				Env:   []corev1.EnvVar{{Name: "DEBUG", Value: "1"}},
+			}}}},
+		},
+	})
+
+	drifts, liveName, err := findDrifts(context.Background(), opts, drift.Options{Salt: "pepper"}, result, workload, clientset, "prod")
+	if err != nil {
+		t.Fatalf("findDrifts() error = %v", err)
+	}
+	if liveName != "prod/Deployment/api" {
+		t.Errorf("findDrifts() live name = %s", liveName)
+	}
+	if len(drifts) != 1 || drifts[0].Layer != drift.LayerSpec || drifts[0].Name != "env DEBUG" || drifts[0].Change != differ.Added {
+		t.Errorf("findDrifts() = %+v, want env DEBUG added", drifts)
+	}
+}
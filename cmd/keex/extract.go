@@ -1,23 +1,46 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/spf13/cobra"
+	"github.com/whywaita/keex/pkg/detector"
+	"github.com/whywaita/keex/pkg/expander"
 	"github.com/whywaita/keex/pkg/extractor"
 	"github.com/whywaita/keex/pkg/formatter"
+	"github.com/whywaita/keex/pkg/redactor"
 	"github.com/whywaita/keex/pkg/resolver"
+	corev1 "k8s.io/api/core/v1"
 )
 
 type extractOptions struct {
-	file      string
-	mode      string
-	container string
-	context   string
-	namespace string
-	redact    bool
+	file            string
+	mode            string
+	container       string
+	kind            string
+	name            string
+	selector        string
+	context         string
+	namespace       string
+	redact          bool
+	redactPolicy    string
+	redactSalt      string
+	redactNames     []string
+	redactPatterns  []string
+	allowMissing    bool
+	podSpecPaths    []string
+	podSpecFile     string
+	volumesDir      string
+	export          bool
+	outputName      string
+	outputNamespace string
+	dialect         string
+	unset           bool
 }
 
 func newExtractCmd() *cobra.Command {
@@ -27,40 +50,306 @@ func newExtractCmd() *cobra.Command {
 		Use:   "extract",
 		Short: "Extract environment variables from Kubernetes manifests",
 		Long: `Extract environment variables from Kubernetes manifests and format them
-for use with docker run or shell commands.`,
+for use with docker run or shell commands.
+
+Output modes:
+` + formatter.Help(),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return runExtract(opts)
 		},
 	}
 
+	addSourceFlags(cmd, opts)
+	cmd.Flags().StringVar(&opts.mode, "mode", "env", "Output mode: "+strings.Join(formatter.Names(), "|"))
+	addRedactFlags(cmd, opts)
+	cmd.Flags().BoolVarP(&opts.export, "export", "e", false, "Add export prefix to shell assignments (env mode)")
+	cmd.Flags().StringVar(&opts.volumesDir, "volumes-dir", "", "Write mounted Secret/ConfigMap volumes below this directory and mount them (docker, docker-run, podman-run and compose-service modes)")
+	cmd.Flags().BoolVar(&opts.unset, "unset", false, "Print commands that remove the variables instead (shell modes)")
+	cmd.Flags().StringVar(&opts.dialect, "dialect", "", "Env file syntax: "+strings.Join(formatter.DialectNames(), "|")+" (dotenv mode, default: compose)")
+	cmd.Flags().StringVar(&opts.outputName, "output-name", "", "Name of the generated ConfigMap and Secret (k8s-manifests mode, default: workload name)")
+	cmd.Flags().StringVar(&opts.outputNamespace, "output-namespace", "", "Namespace of the generated manifests (k8s-manifests mode, default: workload namespace)")
+
+	return cmd
+}
+
+// addSourceFlags registers the flags that select and resolve the manifest,
+// shared by every subcommand that reads one
+func addSourceFlags(cmd *cobra.Command, opts *extractOptions) {
+	addFileFlag(cmd, opts)
+	addResolveFlags(cmd, opts)
+}
+
+// addFileFlag registers the manifest file flag
+func addFileFlag(cmd *cobra.Command, opts *extractOptions) {
 	cmd.Flags().StringVarP(&opts.file, "file", "f", "", "Manifest file path (\"-\" for stdin)")
-	cmd.Flags().StringVar(&opts.mode, "mode", "env", "Output mode: docker|env|dotenv|compose")
-	cmd.Flags().StringVar(&opts.container, "container", "", "Target container name")
+}
+
+// addResolveFlags registers the flags that select workloads in a manifest and
+// resolve their refs
+func addResolveFlags(cmd *cobra.Command, opts *extractOptions) {
+	addSelectFlags(cmd, opts)
 	cmd.Flags().StringVar(&opts.context, "context", "", "kubeconfig context (default: current)")
 	cmd.Flags().StringVar(&opts.namespace, "namespace", "", "Kubernetes namespace (default: manifest/ns)")
-	cmd.Flags().BoolVar(&opts.redact, "redact", false, "Mask secret values in output")
+	cmd.Flags().BoolVar(&opts.allowMissing, "allow-missing", false, "Keep placeholders for missing required Secrets/ConfigMaps instead of failing")
+}
 
-	return cmd
+// addSelectFlags registers the flags that select workloads and containers in
+// a manifest
+func addSelectFlags(cmd *cobra.Command, opts *extractOptions) {
+	cmd.Flags().StringVar(&opts.container, "container", "", "Target container name")
+	cmd.Flags().StringVar(&opts.kind, "kind", "", "Only extract workloads of this kind")
+	cmd.Flags().StringVar(&opts.name, "name", "", "Only extract workloads with this name")
+	cmd.Flags().StringVarP(&opts.selector, "selector", "l", "", "Only extract workloads matching this label selector")
+	cmd.Flags().StringArrayVar(&opts.podSpecPaths, "podspec-path", nil, "Pod template location for a custom kind as [APIVERSION/]KIND=JSONPATH (repeatable)")
+	cmd.Flags().StringVar(&opts.podSpecFile, "podspec-config", "", "Config file with pod template locations for custom kinds")
 }
 
 func runExtract(opts *extractOptions) error {
-	// Validate mode
-	validModes := map[string]bool{"docker": true, "env": true, "dotenv": true, "compose": true}
-	if !validModes[opts.mode] {
-		return fmt.Errorf("invalid mode: %s (must be docker, env, dotenv, or compose)", opts.mode)
+	format, ok := formatter.Lookup(opts.mode)
+	if !ok {
+		return fmt.Errorf("invalid mode: %s (must be one of %s)", opts.mode, strings.Join(formatter.Names(), ", "))
+	}
+	if opts.export && !format.Supports(formatter.OptionExport) {
+		return fmt.Errorf("--export is not supported with %s mode", format.Name)
+	}
+	if opts.unset && !format.Supports(formatter.OptionUnset) {
+		return fmt.Errorf("--unset is not supported with %s mode", format.Name)
+	}
+	if opts.volumesDir != "" && !format.Supports(formatter.OptionMounts) {
+		return fmt.Errorf("--volumes-dir is not supported with %s mode", format.Name)
+	}
+	if (opts.outputName != "" || opts.outputNamespace != "") && !format.Supports(formatter.OptionOutputObject) {
+		return fmt.Errorf("--output-name and --output-namespace are not supported with %s mode", format.Name)
+	}
+	var dialect formatter.Dialect
+	if opts.dialect != "" {
+		if !format.Supports(formatter.OptionDialect) {
+			return fmt.Errorf("--dialect is not supported with %s mode", format.Name)
+		}
+		parsed, err := formatter.ParseDialect(opts.dialect)
+		if err != nil {
+			return err
+		}
+		dialect = parsed
+	}
+
+	r, err := newRedactor(opts)
+	if err != nil {
+		return err
+	}
+
+	loaded, err := loadEnvVars(opts)
+	if err != nil {
+		return err
+	}
+
+	input, err := formatInput(opts, loaded, format)
+	if err != nil {
+		return err
+	}
+	// Formats of the environment alone have nothing to print, the others
+	// still render the workload, its command and its volumes
+	if format.Scope == formatter.ScopeEnv && len(input.EnvVars) == 0 && opts.volumesDir == "" {
+		return fmt.Errorf("failed to extract environment variables: %w", extractor.ErrNoEnvVars)
+	}
+
+	// Redaction applies to every mode, formatters write values as they are
+	if r != nil {
+		input.EnvVars = r.Redact(input.EnvVars)
+	}
+
+	output, err := format.Formatter.Format(input, formatter.Options{
+		Export:          opts.export,
+		OutputName:      opts.outputName,
+		OutputNamespace: opts.outputNamespace,
+		Dialect:         dialect,
+		Unset:           opts.unset,
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(output)
+	return nil
+}
+
+// addRedactFlags registers the flags that control redaction
+func addRedactFlags(cmd *cobra.Command, opts *extractOptions) {
+	cmd.Flags().BoolVar(&opts.redact, "redact", false, "Mask secret values in output")
+	cmd.Flags().StringVar(&opts.redactPolicy, "redact-policy", "", "How to mask values: "+strings.Join(redactor.PolicyNames(), "|")+" (implies --redact, default: full)")
+	cmd.Flags().StringVar(&opts.redactSalt, "redact-salt", "", "Salt for the hash redaction policy (default: random)")
+	cmd.Flags().StringArrayVar(&opts.redactNames, "redact-name", nil, "Also redact the variable with this name (repeatable, implies --redact)")
+	cmd.Flags().StringArrayVar(&opts.redactPatterns, "redact-pattern", nil, "Also redact variables whose name matches this regular expression (repeatable, implies --redact)")
+}
+
+// newRedactor returns the redactor configured by the flags, or nil when no
+// redaction flag was given
+func newRedactor(opts *extractOptions) (*redactor.Redactor, error) {
+	if !opts.redact && opts.redactPolicy == "" && len(opts.redactNames) == 0 && len(opts.redactPatterns) == 0 {
+		return nil, nil
+	}
+	r, err := redactor.New(redactor.Options{
+		Policy:   redactor.Policy(opts.redactPolicy),
+		Salt:     opts.redactSalt,
+		Names:    opts.redactNames,
+		Patterns: opts.redactPatterns,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if opts.redactSalt == "" && r.Salt() != "" {
+		fmt.Fprintf(os.Stderr, "Hashing with the random salt %s, pass it with --redact-salt to compare fingerprints across runs\n", r.Salt())
+	}
+	return r, nil
+}
+
+// formatInput narrows the manifest down to what the format renders and
+// materializes mounted volumes when --volumes-dir is given
+func formatInput(opts *extractOptions, loaded *loadedManifest, format formatter.Format) (formatter.Input, error) {
+	input := formatter.Input{EnvVars: loaded.envVars}
+	if opts.file != "-" {
+		manifestPath, err := filepath.Abs(opts.file)
+		if err != nil {
+			return formatter.Input{}, fmt.Errorf("failed to resolve manifest path: %w", err)
+		}
+		input.ManifestPath = manifestPath
+	}
+
+	switch {
+	case format.Scope == formatter.ScopeWorkload:
+		workload, err := selectWorkload(loaded.result)
+		if err != nil {
+			return formatter.Input{}, err
+		}
+		input.Workload = &workload
+		input.EnvVars = workloadEnvVars(loaded.envVars, workload)
+		if opts.container != "" {
+			if input.Container, err = workload.FindContainer(opts.container); err != nil {
+				return formatter.Input{}, err
+			}
+		}
+	case format.Scope == formatter.ScopeContainer || opts.volumesDir != "":
+		workload, container, err := selectContainer(loaded.result, opts.container)
+		if err != nil {
+			return formatter.Input{}, err
+		}
+		input.Workload = &workload
+		input.Container = container
+		if format.Scope == formatter.ScopeContainer {
+			input.EnvVars = containerEnvVars(loaded.envVars, workload, container)
+		}
+	}
+
+	if opts.volumesDir == "" {
+		return input, nil
+	}
+
+	// Only write the volumes of the containers that end up in the output
+	var containers []*corev1.Container
+	if input.Container != nil {
+		containers = append(containers, input.Container)
+	} else {
+		for i := range input.Workload.Pod.Spec.InitContainers {
+			containers = append(containers, &input.Workload.Pod.Spec.InitContainers[i])
+		}
+		for i := range input.Workload.Pod.Spec.Containers {
+			containers = append(containers, &input.Workload.Pod.Spec.Containers[i])
+		}
+	}
+
+	input.Mounts = make(map[string][]formatter.Mount, len(containers))
+	for _, container := range containers {
+		mounts, err := materializeVolumes(opts, loaded, *input.Workload, container)
+		if err != nil {
+			return formatter.Input{}, err
+		}
+		input.Mounts[container.Name] = mounts
+	}
+	return input, nil
+}
+
+// loadedManifest is a manifest with its resolved environment variables
+type loadedManifest struct {
+	result   *extractor.Result
+	resolver *resolver.Resolver
+	envVars  []extractor.EnvVar
+}
+
+// loadEnvVars reads the manifest and returns its resolved environment
+// variables with the kubelet's precedence and expansion rules applied, along
+// with everything else found in the manifest. Inline values that look like
+// secrets are marked as such.
+func loadEnvVars(opts *extractOptions) (*loadedManifest, error) {
+	result, err := readManifest(opts)
+	if err != nil {
+		return nil, err
+	}
+	result.EnvVars = detector.Mark(result.EnvVars)
+
+	// Resolve secrets/configmaps from the manifest first, then from the cluster
+	// if kubeconfig is available
+	res, err := resolver.New(resolver.Options{
+		Context:   opts.context,
+		Namespace: opts.namespace,
+	})
+	if err != nil {
+		// Kubeconfig is not available, refs missing from the manifest keep placeholder values
+		res = resolver.NewOffline(opts.namespace)
+	}
+	res.AddObjects(result.Secrets, result.ConfigMaps)
+
+	envVars, err := resolveEnvVars(opts, res, result.EnvVars)
+	if err != nil {
+		return nil, err
+	}
+	return &loadedManifest{
+		result:   result,
+		resolver: res,
+		envVars:  envVars,
+	}, nil
+}
+
+// resolveEnvVars resolves refs with res and applies the kubelet's precedence
+// and expansion rules
+func resolveEnvVars(opts *extractOptions, res *resolver.Resolver, envVars []extractor.EnvVar) ([]extractor.EnvVar, error) {
+	envVars, err := res.ResolveAll(envVars)
+	if err != nil {
+		var missingErr *resolver.MissingRefsError
+		if !opts.allowMissing || !errors.As(err, &missingErr) {
+			return nil, fmt.Errorf("failed to resolve secrets: %w", err)
+		}
+		// Missing refs keep their placeholder values
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
 	}
 
+	// Expand $(VAR_NAME) references like the kubelet does. This comes before
+	// deduplication: an entry sees every envFrom value and the env entries
+	// defined before it, including those a later entry of the same name
+	// overrides.
+	envVars = expander.ExpandAll(envVars)
+
+	// Apply the kubelet's precedence rules to duplicate names
+	envVars, shadowings := resolver.Deduplicate(envVars)
+	for _, shadowing := range shadowings {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", shadowing)
+	}
+	return envVars, nil
+}
+
+// readManifest reads the manifest and extracts the env vars of the selected
+// workloads as they are written, without resolving refs
+func readManifest(opts *extractOptions) (*extractor.Result, error) {
 	// Read manifest
 	var reader io.Reader
 	switch opts.file {
 	case "":
-		return fmt.Errorf("manifest file is required")
+		return nil, fmt.Errorf("manifest file is required")
 	case "-":
 		reader = os.Stdin
 	default:
 		file, err := os.Open(opts.file)
 		if err != nil {
-			return fmt.Errorf("failed to open file: %w", err)
+			return nil, fmt.Errorf("failed to open file: %w", err)
 		}
 		defer func() {
 			if err := file.Close(); err != nil {
@@ -70,42 +359,139 @@ func runExtract(opts *extractOptions) error {
 		reader = file
 	}
 
+	podSpecPaths, err := loadPodSpecPaths(opts)
+	if err != nil {
+		return nil, err
+	}
+
 	// Extract environment variables
 	ext := extractor.New()
-	envVars, err := ext.Extract(reader, extractor.Options{
-		Container: opts.container,
+	result, err := ext.ExtractAll(reader, extractor.Options{
+		Container:    opts.container,
+		Kind:         opts.kind,
+		Name:         opts.name,
+		Selector:     opts.selector,
+		PodSpecPaths: podSpecPaths,
 	})
 	if err != nil {
-		return fmt.Errorf("failed to extract environment variables: %w", err)
+		return nil, fmt.Errorf("failed to extract environment variables: %w", err)
 	}
+	return result, nil
+}
 
-	// Try to resolve secrets/configmaps if kubeconfig is available
-	res, err := resolver.New(resolver.Options{
-		Context:   opts.context,
-		Namespace: opts.namespace,
-	})
-	if err == nil {
-		// Kubeconfig is available, resolve secrets/configmaps
-		envVars, err = res.ResolveAll(envVars)
+// materializeVolumes writes the Secret and ConfigMap volumes mounted by the
+// container into opts.volumesDir and returns matching bind mounts
+func materializeVolumes(opts *extractOptions, loaded *loadedManifest, workload extractor.Workload, container *corev1.Container) ([]formatter.Mount, error) {
+	volumes, err := loaded.resolver.ResolveVolumes(workload.Pod, container)
+	if err != nil {
+		var missingErr *resolver.MissingRefsError
+		if !opts.allowMissing || !errors.As(err, &missingErr) {
+			return nil, fmt.Errorf("failed to resolve volumes: %w", err)
+		}
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+
+	dir, err := filepath.Abs(opts.volumesDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve volumes directory: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create volumes directory: %w", err)
+	}
+	// Secrets are written here, keep the directory private even if it existed
+	if err := os.Chmod(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create volumes directory: %w", err)
+	}
+
+	mounts := make([]formatter.Mount, 0, len(volumes))
+	for _, volume := range volumes {
+		hostPath, err := volume.Write(dir)
 		if err != nil {
-			return fmt.Errorf("failed to resolve secrets: %w", err)
+			return nil, err
 		}
+		mounts = append(mounts, formatter.Mount{
+			HostPath:      hostPath,
+			ContainerPath: volume.MountPath,
+			ReadOnly:      true,
+		})
 	}
-	// If kubeconfig is not available, just continue with placeholder values
+	return mounts, nil
+}
 
-	// Format output
-	var output string
-	switch opts.mode {
-	case "docker":
-		output = formatter.FormatDocker(envVars, opts.redact)
-	case "env":
-		output = formatter.FormatShell(envVars, false, opts.redact)
-	case "dotenv":
-		output = formatter.FormatDotenv(envVars)
-	case "compose":
-		output = formatter.FormatCompose(envVars)
+// selectContainer returns the single workload of the manifest and its
+// selected container
+func selectContainer(result *extractor.Result, containerName string) (extractor.Workload, *corev1.Container, error) {
+	workload, err := selectWorkload(result)
+	if err != nil {
+		return extractor.Workload{}, nil, err
 	}
 
-	fmt.Println(output)
-	return nil
+	container, err := workload.FindContainer(containerName)
+	if err != nil {
+		return extractor.Workload{}, nil, err
+	}
+	return workload, container, nil
+}
+
+// selectWorkload returns the single workload of the manifest
+func selectWorkload(result *extractor.Result) (extractor.Workload, error) {
+	if len(result.Workloads) != 1 {
+		return extractor.Workload{}, fmt.Errorf("found %d workloads, select one with --kind, --name or --selector", len(result.Workloads))
+	}
+	return result.Workloads[0], nil
+}
+
+// workloadEnvVars returns the env vars that belong to the given workload
+func workloadEnvVars(envVars []extractor.EnvVar, workload extractor.Workload) []extractor.EnvVar {
+	var filtered []extractor.EnvVar
+	for _, env := range envVars {
+		if env.Origin.Kind == workload.Kind && env.Origin.Namespace == workload.Namespace && env.Origin.Name == workload.Name {
+			filtered = append(filtered, env)
+		}
+	}
+	return filtered
+}
+
+// containerEnvVars returns the env vars that belong to the given container
+func containerEnvVars(envVars []extractor.EnvVar, workload extractor.Workload, container *corev1.Container) []extractor.EnvVar {
+	var filtered []extractor.EnvVar
+	for _, env := range workloadEnvVars(envVars, workload) {
+		if env.Origin.Container == container.Name {
+			filtered = append(filtered, env)
+		}
+	}
+	return filtered
+}
+
+// loadPodSpecPaths collects pod template mappings from --podspec-path flags
+// and the --podspec-config file. Flags take precedence over the file.
+func loadPodSpecPaths(opts *extractOptions) ([]extractor.PodSpecPath, error) {
+	var mappings []extractor.PodSpecPath
+	for _, value := range opts.podSpecPaths {
+		mapping, err := extractor.ParsePodSpecPath(value)
+		if err != nil {
+			return nil, err
+		}
+		mappings = append(mappings, mapping)
+	}
+
+	if opts.podSpecFile != "" {
+		file, err := os.Open(opts.podSpecFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open pod spec config: %w", err)
+		}
+		defer func() {
+			if err := file.Close(); err != nil {
+				fmt.Fprintf(os.Stderr, "failed to close file: %v\n", err)
+			}
+		}()
+
+		fromFile, err := extractor.LoadPodSpecConfig(file)
+		if err != nil {
+			return nil, err
+		}
+		mappings = append(mappings, fromFile...)
+	}
+
+	return mappings, nil
 }
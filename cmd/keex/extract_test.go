@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/whywaita/keex/pkg/extractor"
+	"github.com/whywaita/keex/pkg/formatter"
+)
+
+const volumeOnlyManifest = `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app-config
+data:
+  app.conf: debug=true
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: api
+spec:
+  template:
+    spec:
+      containers:
+      - name: app
+        image: app:1.0
+        volumeMounts:
+        - name: config
+          mountPath: /etc/app
+      volumes:
+      - name: config
+        configMap:
+          name: app-config
+`
+
+// formatManifest writes the manifest to a file and renders it in the given
+// mode like keex extract, resolving refs offline
+func formatManifest(t *testing.T, manifest string, opts *extractOptions) string {
+	t.Helper()
+	t.Setenv("KUBECONFIG", filepath.Join(t.TempDir(), "missing"))
+	opts.file = filepath.Join(t.TempDir(), "manifest.yaml")
+	if err := os.WriteFile(opts.file, []byte(manifest), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := loadEnvVars(opts)
+	if err != nil {
+		t.Fatalf("loadEnvVars() error = %v", err)
+	}
+	format, ok := formatter.Lookup(opts.mode)
+	if !ok {
+		t.Fatalf("unknown mode %s", opts.mode)
+	}
+	input, err := formatInput(opts, loaded, format)
+	if err != nil {
+		t.Fatalf("formatInput() error = %v", err)
+	}
+	output, err := format.Formatter.Format(input, formatter.Options{})
+	if err != nil {
+		t.Fatalf("Format() error = %v", err)
+	}
+	return output
+}
+
+func TestFormatInputVolumeOnly(t *testing.T) {
+	output := formatManifest(t, volumeOnlyManifest, &extractOptions{mode: "docker-run", volumesDir: t.TempDir()})
+	if !strings.Contains(output, ":/etc/app:ro'") || !strings.HasSuffix(output, "'app:1.0'") {
+		t.Errorf("Format() = %s, want the volume mounted into docker run", output)
+	}
+
+	// Formats of the environment alone have nothing to print
+	opts := &extractOptions{file: filepath.Join(t.TempDir(), "deployment.yaml"), mode: "env"}
+	if err := os.WriteFile(opts.file, []byte(volumeOnlyManifest), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := runExtract(opts); !errors.Is(err, extractor.ErrNoEnvVars) {
+		t.Errorf("runExtract(env) error = %v, want ErrNoEnvVars", err)
+	}
+}
+
+func TestFormatInputWithoutEnv(t *testing.T) {
+	const manifest = `apiVersion: v1
+kind: Pod
+metadata:
+  name: api
+spec:
+  containers:
+  - name: app
+    image: app:1.0
+    args: ["serve"]
+`
+	for _, engine := range []string{"docker", "podman"} {
+		output := formatManifest(t, manifest, &extractOptions{mode: engine + "-run"})
+		if expected := engine + " run --rm 'app:1.0' 'serve'"; output != expected {
+			t.Errorf("Format(%s-run) = %q, want %q", engine, output, expected)
+		}
+	}
+}
+
+func TestFormatInputComposeServiceWithoutEnv(t *testing.T) {
+	const manifest = `apiVersion: v1
+kind: Pod
+metadata:
+  name: api
+spec:
+  initContainers:
+  - name: migrate
+    image: migrate:1.0
+  containers:
+  - name: app
+    image: app:1.0
+  - name: proxy
+    image: proxy:1.0
+`
+	output := formatManifest(t, manifest, &extractOptions{mode: "compose-service"})
+	for _, service := range []string{"  migrate:", "  app:", "  proxy:"} {
+		if !strings.Contains(output, service) {
+			t.Errorf("Format(compose-service) =\n%s\nwant service %s", output, strings.TrimSpace(service))
+		}
+	}
+}
+
+func TestFormatInputK8sManifestsWithoutEnv(t *testing.T) {
+	output := formatManifest(t, volumeOnlyManifest, &extractOptions{mode: "k8s-manifests"})
+	if strings.Contains(output, "---") || !strings.Contains(output, "kind: Deployment") || !strings.Contains(output, "mountPath: /etc/app") {
+		t.Errorf("Format(k8s-manifests) =\n%s\nwant the workload unchanged", output)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 
@@ -13,6 +14,10 @@ var (
 
 func main() {
 	if err := newRootCmd().Execute(); err != nil {
+		var exitErr *exitCodeError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.code)
+		}
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
@@ -28,6 +33,10 @@ and formats them for use with docker run or shell commands.`,
 	}
 
 	cmd.AddCommand(newExtractCmd())
+	cmd.AddCommand(newRunCmd())
+	cmd.AddCommand(newScanCmd())
+	cmd.AddCommand(newDiffCmd())
+	cmd.AddCommand(newDriftCmd())
 
 	return cmd
 }
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/whywaita/keex/pkg/extractor"
+)
+
+type runOptions struct {
+	extractOptions
+	cleanEnv bool
+}
+
+// exitCodeError makes keex exit with the exit code of the child process
+type exitCodeError struct {
+	code int
+}
+
+func (e *exitCodeError) Error() string {
+	return fmt.Sprintf("exit status %d", e.code)
+}
+
+func newRunCmd() *cobra.Command {
+	opts := &runOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "run -f FILE [flags] -- COMMAND [ARGS...]",
+		Short: "Run a local command with the environment of a Kubernetes workload",
+		Long: `Run a local command with the environment variables extracted from Kubernetes
+manifests. The command is started directly without a shell, signals are passed
+through to it and keex exits with its exit code.`,
+		Args:          cobra.MinimumNArgs(1),
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runRun(opts, args)
+		},
+	}
+
+	// Flags after the command belong to the command
+	cmd.Flags().SetInterspersed(false)
+
+	addSourceFlags(cmd, &opts.extractOptions)
+	cmd.Flags().BoolVar(&opts.cleanEnv, "clean-env", false, "Start from an empty environment instead of merging onto the current one")
+
+	return cmd
+}
+
+func runRun(opts *runOptions, args []string) error {
+	loaded, err := loadEnvVars(&opts.extractOptions)
+	if err != nil {
+		return err
+	}
+	envVars, err := singleContainerEnvVars(loaded.envVars)
+	if err != nil {
+		return err
+	}
+
+	child := exec.Command(args[0], args[1:]...)
+	child.Stdin = os.Stdin
+	child.Stdout = os.Stdout
+	child.Stderr = os.Stderr
+	child.Env = buildEnviron(envVars, opts.cleanEnv)
+
+	// Catch signals before starting the child, so that one arriving in
+	// between does not kill keex and orphan it. They are passed through to the
+	// child until it exits.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, forwardedSignals...)
+	defer signal.Stop(signals)
+
+	if err := child.Start(); err != nil {
+		return fmt.Errorf("failed to start %s: %w", args[0], err)
+	}
+
+	go func() {
+		for sig := range signals {
+			_ = child.Process.Signal(sig)
+		}
+	}()
+
+	err = child.Wait()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return &exitCodeError{code: exitCode(exitErr.ProcessState)}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to run %s: %w", args[0], err)
+	}
+	return nil
+}
+
+// singleContainerEnvVars returns the env vars of the one container a process
+// environment is built from. Init containers only run before the app, so
+// their variables are dropped when regular containers have any; env vars of
+// several regular containers can not be merged and are rejected.
+func singleContainerEnvVars(envVars []extractor.EnvVar) ([]extractor.EnvVar, error) {
+	if sameOrigin(envVars) {
+		return envVars, nil
+	}
+
+	var regular []extractor.EnvVar
+	for _, env := range envVars {
+		if !env.Origin.InitContainer {
+			regular = append(regular, env)
+		}
+	}
+	if len(regular) == 0 || !sameOrigin(regular) {
+		return nil, fmt.Errorf("environment variables come from multiple containers, select one with --container, --kind or --name")
+	}
+	return regular, nil
+}
+
+// sameOrigin reports whether all env vars belong to the same container
+func sameOrigin(envVars []extractor.EnvVar) bool {
+	for _, env := range envVars {
+		if env.Origin != envVars[0].Origin {
+			return false
+		}
+	}
+	return true
+}
+
+// buildEnviron returns the child environment. Extracted variables override
+// variables of the current environment unless cleanEnv is set.
+func buildEnviron(envVars []extractor.EnvVar, cleanEnv bool) []string {
+	var environ []string
+	if !cleanEnv {
+		overridden := make(map[string]bool, len(envVars))
+		for _, env := range envVars {
+			overridden[env.Name] = true
+		}
+		for _, kv := range os.Environ() {
+			name, _, _ := strings.Cut(kv, "=")
+			if !overridden[name] {
+				environ = append(environ, kv)
+			}
+		}
+	}
+
+	for _, env := range envVars {
+		// Skip comment entries
+		if strings.HasPrefix(env.Name, "#") {
+			continue
+		}
+		environ = append(environ, env.Name+"="+env.Value)
+	}
+	return environ
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/whywaita/keex/pkg/extractor"
+)
+
+func TestSingleContainerEnvVars(t *testing.T) {
+	app := extractor.Origin{Kind: "Deployment", Name: "api", Container: "app"}
+	sidecar := extractor.Origin{Kind: "Deployment", Name: "api", Container: "proxy"}
+	migrate := extractor.Origin{Kind: "Deployment", Name: "api", Container: "migrate", InitContainer: true}
+
+	tests := []struct {
+		name     string
+		envVars  []extractor.EnvVar
+		expected []string
+		wantErr  bool
+	}{
+		{
+			name:     "one container",
+			envVars:  []extractor.EnvVar{{Name: "A", Origin: app}, {Name: "B", Origin: app}},
+			expected: []string{"A", "B"},
+		},
+		{
+			name:     "init containers are dropped",
+			envVars:  []extractor.EnvVar{{Name: "MIGRATE", Origin: migrate}, {Name: "A", Origin: app}},
+			expected: []string{"A"},
+		},
+		{
+			name:     "only an init container",
+			envVars:  []extractor.EnvVar{{Name: "MIGRATE", Origin: migrate}},
+			expected: []string{"MIGRATE"},
+		},
+		{
+			name:     "no env vars",
+			expected: nil,
+		},
+		{
+			name:    "several regular containers",
+			envVars: []extractor.EnvVar{{Name: "MIGRATE", Origin: migrate}, {Name: "A", Origin: app}, {Name: "B", Origin: sidecar}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			envVars, err := singleContainerEnvVars(tt.envVars)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("singleContainerEnvVars() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var names []string
+			for _, env := range envVars {
+				names = append(names, env.Name)
+			}
+			if strings.Join(names, ",") != strings.Join(tt.expected, ",") {
+				t.Errorf("singleContainerEnvVars() = %v, want %v", names, tt.expected)
+			}
+		})
+	}
+}
+
+func TestBuildEnviron(t *testing.T) {
+	t.Setenv("KEEX_TEST_KEPT", "host")
+	t.Setenv("KEEX_TEST_OVERRIDDEN", "host")
+	envVars := []extractor.EnvVar{
+		{Name: "KEEX_TEST_OVERRIDDEN", Value: "workload"},
+		{Name: "EMPTY", Value: ""},
+		{Name: "# from secret: db", Source: extractor.SourceSecret},
+	}
+
+	merged := buildEnviron(envVars, false)
+	contains := func(environ []string, kv string) bool {
+		for _, entry := range environ {
+			if entry == kv {
+				return true
+			}
+		}
+		return false
+	}
+	if !contains(merged, "KEEX_TEST_KEPT=host") || !contains(merged, "KEEX_TEST_OVERRIDDEN=workload") || !contains(merged, "EMPTY=") {
+		t.Errorf("buildEnviron() = %v", merged)
+	}
+	if contains(merged, "KEEX_TEST_OVERRIDDEN=host") {
+		t.Error("buildEnviron() kept a host variable the workload overrides")
+	}
+
+	clean := buildEnviron(envVars, true)
+	if len(clean) != 2 || clean[0] != "KEEX_TEST_OVERRIDDEN=workload" || clean[1] != "EMPTY=" {
+		t.Errorf("buildEnviron(cleanEnv) = %v, want only the workload variables without comments", clean)
+	}
+}
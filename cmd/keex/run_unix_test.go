@@ -0,0 +1,69 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const runManifest = `apiVersion: v1
+kind: Pod
+metadata:
+  name: api
+spec:
+  initContainers:
+  - name: migrate
+    image: migrate
+    env:
+    - name: MIGRATE
+      value: "1"
+  containers:
+  - name: app
+    image: app
+    env:
+    - name: GREETING
+      value: hello
+`
+
+func TestRunRun(t *testing.T) {
+	// Resolve offline
+	t.Setenv("KUBECONFIG", filepath.Join(t.TempDir(), "missing"))
+	manifest := filepath.Join(t.TempDir(), "pod.yaml")
+	if err := os.WriteFile(manifest, []byte(runManifest), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	opts := &runOptions{extractOptions: extractOptions{file: manifest}, cleanEnv: true}
+
+	tests := []struct {
+		name   string
+		script string
+		code   int
+	}{
+		{name: "success", script: `test "$GREETING" = hello && test -z "$MIGRATE"`, code: 0},
+		{name: "exit code", script: "exit 3", code: 3},
+		{name: "signal", script: "kill -TERM $$", code: 143},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := runRun(opts, []string{"/bin/sh", "-c", tt.script})
+			if tt.code == 0 {
+				if err != nil {
+					t.Fatalf("runRun() error = %v", err)
+				}
+				return
+			}
+			var exitErr *exitCodeError
+			if !errors.As(err, &exitErr) || exitErr.code != tt.code {
+				t.Errorf("runRun() error = %v, want exit code %d", err, tt.code)
+			}
+		})
+	}
+
+	if err := runRun(opts, []string{filepath.Join(t.TempDir(), "missing")}); err == nil || errors.As(err, new(*exitCodeError)) {
+		t.Errorf("runRun() error = %v, want a start failure", err)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build windows
+
+package main
+
+import "os"
+
+var forwardedSignals = []os.Signal{
+	os.Interrupt,
+}
+
+func exitCode(state *os.ProcessState) int {
+	return state.ExitCode()
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/whywaita/keex/pkg/detector"
+	"github.com/whywaita/keex/pkg/extractor"
+)
+
+type scanOptions struct {
+	extractOptions
+	ignore []string
+}
+
+func newScanCmd() *cobra.Command {
+	opts := &scanOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "scan -f FILE",
+		Short: "Find secrets hardcoded in env values of Kubernetes manifests",
+		Long: `Find inline env values that look like secrets: variables named like
+*_PASSWORD, *_TOKEN or *_KEY, AWS access keys, GitHub tokens, JWTs, PEM private
+keys and high-entropy strings. Values read from Secrets and ConfigMaps are not
+inspected. keex exits with status 1 when anything is found, for use in CI.`,
+		Args:          cobra.NoArgs,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runScan(opts)
+		},
+	}
+
+	addFileFlag(cmd, &opts.extractOptions)
+	addSelectFlags(cmd, &opts.extractOptions)
+	cmd.Flags().StringArrayVar(&opts.ignore, "ignore", nil, "Variable name to skip (repeatable)")
+
+	return cmd
+}
+
+func runScan(opts *scanOptions) error {
+	result, err := readManifest(&opts.extractOptions)
+	if err != nil {
+		return err
+	}
+
+	ignored := make(map[string]bool, len(opts.ignore))
+	for _, name := range opts.ignore {
+		ignored[name] = true
+	}
+	var findings []detector.Finding
+	for _, finding := range detector.Scan(result.EnvVars) {
+		if !ignored[finding.EnvVar.Name] {
+			findings = append(findings, finding)
+		}
+	}
+
+	if len(findings) == 0 {
+		fmt.Fprintln(os.Stderr, "No hardcoded secrets found")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "RESOURCE\tFIELD\tVARIABLE\tRULE\tREASON")
+	for _, finding := range findings {
+		origin := finding.EnvVar.Origin
+		resource := origin.Kind + "/" + origin.Name
+		if origin.Namespace != "" {
+			resource = origin.Kind + "/" + origin.Namespace + "/" + origin.Name
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", resource, envFieldPath(result.Workloads, finding.EnvVar), finding.EnvVar.Name, finding.Rule, finding.Reason)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Found %d possible hardcoded secrets\n", len(findings))
+	return &exitCodeError{code: 1}
+}
+
+// envFieldPath returns where the env entry is written in the pod spec of its
+// workload, e.g. containers[0].env[2], or the container name when the entry
+// can not be found
+func envFieldPath(workloads []extractor.Workload, env extractor.EnvVar) string {
+	for _, workload := range workloads {
+		if workload.Kind != env.Origin.Kind || workload.Namespace != env.Origin.Namespace || workload.Name != env.Origin.Name {
+			continue
+		}
+
+		field, containers := "containers", workload.Pod.Spec.Containers
+		if env.Origin.InitContainer {
+			field, containers = "initContainers", workload.Pod.Spec.InitContainers
+		}
+		for i, container := range containers {
+			if container.Name != env.Origin.Container {
+				continue
+			}
+			for j, entry := range container.Env {
+				if entry.Name == env.Name && entry.ValueFrom == nil && entry.Value == env.Value {
+					return fmt.Sprintf("%s[%d].env[%d]", field, i, j)
+				}
+			}
+		}
+	}
+	return "container " + env.Origin.Container
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunScan(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+		code     int
+	}{
+		{
+			name: "no env",
+			manifest: `apiVersion: v1
+kind: Pod
+metadata:
+  name: api
+spec:
+  containers:
+  - name: app
+    image: app
+`,
+		},
+		{
+			name: "no workloads",
+			manifest: `apiVersion: v1
+kind: Service
+metadata:
+  name: api
+`,
+		},
+		{
+			name: "clean env",
+			manifest: `apiVersion: v1
+kind: Pod
+metadata:
+  name: api
+spec:
+  containers:
+  - name: app
+    image: app
+    env:
+    - name: LOG_LEVEL
+      value: debug
+`,
+		},
+		{
+			name: "hardcoded secret",
+			manifest: `apiVersion: v1
+kind: Pod
+metadata:
+  name: api
+spec:
+  containers:
+  - name: app
+    image: app
+    env:
+    - name: DB_PASSWORD
+      value: hunter2
+`,
+			code: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manifest := filepath.Join(t.TempDir(), "manifest.yaml")
+			if err := os.WriteFile(manifest, []byte(tt.manifest), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			err := runScan(&scanOptions{extractOptions: extractOptions{file: manifest}})
+			if tt.code == 0 {
+				if err != nil {
+					t.Errorf("runScan() error = %v, want no findings", err)
+				}
+				return
+			}
+			var exitErr *exitCodeError
+			if !errors.As(err, &exitErr) || exitErr.code != tt.code {
+				t.Errorf("runScan() error = %v, want exit code %d", err, tt.code)
+			}
+		})
+	}
+}
@@ -2,15 +2,24 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"os"
 	"strings"
 
 	"github.com/spf13/cobra"
+	"github.com/whywaita/keex/pkg/detector"
+	"github.com/whywaita/keex/pkg/differ"
+	"github.com/whywaita/keex/pkg/expander"
 	"github.com/whywaita/keex/pkg/extractor"
+	"github.com/whywaita/keex/pkg/fetcher"
 	"github.com/whywaita/keex/pkg/formatter"
+	"github.com/whywaita/keex/pkg/procenv"
+	"github.com/whywaita/keex/pkg/redactor"
 	"github.com/whywaita/keex/pkg/resolver"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 	"k8s.io/client-go/kubernetes"
 )
@@ -43,7 +52,8 @@ func NewCmd(streams genericclioptions.IOStreams) *cobra.Command {
 		Long: `kubectl-eex is a kubectl plugin that extracts environment variables from Kubernetes resources
 and formats them for use with docker run or shell commands.
 
-Supports Deployment, StatefulSet, DaemonSet, Job, CronJob, and Pod resources.
+Supports Deployment, StatefulSet, DaemonSet, ReplicaSet, ReplicationController, Job, CronJob,
+PodTemplate, and Pod resources.
 
 Examples:
   # Extract env vars from a deployment (both formats supported)
@@ -58,7 +68,17 @@ Examples:
   kubectl eex deployment/my-app --format docker
 
   # Output in shell format with export
-  kubectl eex pod/mypod --format shell --export`,
+  kubectl eex pod/mypod --format shell --export
+
+  # Read the environment of the running process instead of the pod spec,
+  # including values injected by webhooks or an entrypoint wrapper
+  kubectl eex deployment/my-app --from-process
+
+  # Show where the running process differs from the pod spec
+  kubectl eex deployment/my-app --from-process --diff
+
+Output formats:
+` + formatter.Help(),
 		Version: version,
 		Args:    cobra.RangeArgs(1, 2),
 		RunE: func(cmd *cobra.Command, args []string) error {
@@ -69,13 +89,78 @@ Examples:
 	o.configFlags.AddFlags(cmd.Flags())
 
 	cmd.Flags().StringP("container", "c", "", "Specify container name (optional)")
-	cmd.Flags().StringP("format", "f", "docker", "Output format: docker, shell, dotenv, compose")
+	cmd.Flags().StringP("format", "f", "docker", "Output format: "+strings.Join(formatter.Names(), ", "))
 	cmd.Flags().BoolP("export", "e", false, "Add export prefix for shell format")
+	cmd.Flags().Bool("redact", false, "Mask secret values in output")
+	cmd.Flags().String("redact-policy", "", "How to mask values: "+strings.Join(redactor.PolicyNames(), "|")+" (implies --redact, default: full)")
+	cmd.Flags().String("redact-salt", "", "Salt for the hash redaction policy (default: random)")
+	cmd.Flags().StringArray("redact-name", nil, "Also redact the variable with this name (repeatable, implies --redact)")
+	cmd.Flags().StringArray("redact-pattern", nil, "Also redact variables whose name matches this regular expression (repeatable, implies --redact)")
+	cmd.Flags().Bool("unset", false, "Print commands that remove the variables instead (shell formats)")
+	cmd.Flags().String("dialect", "", "Env file syntax: "+strings.Join(formatter.DialectNames(), "|")+" (dotenv format, default: compose)")
+	cmd.Flags().String("output-name", "", "Name of the generated ConfigMap and Secret (k8s-manifests format, default: resource name)")
+	cmd.Flags().String("output-namespace", "", "Namespace of the generated manifests (k8s-manifests format, default: resource namespace)")
+	cmd.Flags().Bool("allow-missing", false, "Keep placeholders for missing required Secrets/ConfigMaps instead of failing")
+	cmd.Flags().String("pod-name", "", "Override metadata.name for fieldRef env vars")
+	cmd.Flags().String("pod-ip", "", "Override status.podIP for fieldRef env vars")
+	cmd.Flags().String("host-ip", "", "Override status.hostIP for fieldRef env vars")
+	cmd.Flags().String("node-name", "", "Override spec.nodeName for fieldRef env vars")
+	cmd.Flags().String("from-process", "", "Read the environment of the running container through exec instead of the pod spec: "+strings.Join(procenv.MethodNames(), "|"))
+	cmd.Flags().Lookup("from-process").NoOptDefVal = string(procenv.MethodAuto)
+	cmd.Flags().Bool("diff", false, "With --from-process, compare the process environment with the pod spec instead of printing it")
+	cmd.Flags().Bool("show-extra", false, "With --diff, also list variables only the process has, such as the image's ENV (HOSTNAME and service links are left out)")
 
 	return cmd
 }
 
 func runExtract(o *Options, cmd *cobra.Command, args []string) error {
+	formatFlag, _ := cmd.Flags().GetString("format")
+	format, ok := formatter.Lookup(formatFlag)
+	if !ok {
+		return fmt.Errorf("invalid format: %s (must be one of %s)", formatFlag, strings.Join(formatter.Names(), ", "))
+	}
+	exportFlag, _ := cmd.Flags().GetBool("export")
+	if exportFlag && !format.Supports(formatter.OptionExport) {
+		return fmt.Errorf("--export is not supported with %s format", format.Name)
+	}
+	unsetFlag, _ := cmd.Flags().GetBool("unset")
+	if unsetFlag && !format.Supports(formatter.OptionUnset) {
+		return fmt.Errorf("--unset is not supported with %s format", format.Name)
+	}
+	outputName, _ := cmd.Flags().GetString("output-name")
+	outputNamespace, _ := cmd.Flags().GetString("output-namespace")
+	if (outputName != "" || outputNamespace != "") && !format.Supports(formatter.OptionOutputObject) {
+		return fmt.Errorf("--output-name and --output-namespace are not supported with %s format", format.Name)
+	}
+	var dialect formatter.Dialect
+	if dialectFlag, _ := cmd.Flags().GetString("dialect"); dialectFlag != "" {
+		if !format.Supports(formatter.OptionDialect) {
+			return fmt.Errorf("--dialect is not supported with %s format", format.Name)
+		}
+		parsed, err := formatter.ParseDialect(dialectFlag)
+		if err != nil {
+			return err
+		}
+		dialect = parsed
+	}
+
+	var processMethod procenv.Method
+	if fromProcess, _ := cmd.Flags().GetString("from-process"); fromProcess != "" {
+		method, err := procenv.ParseMethod(fromProcess)
+		if err != nil {
+			return err
+		}
+		processMethod = method
+	}
+	diffFlag, _ := cmd.Flags().GetBool("diff")
+	if diffFlag && processMethod == "" {
+		return fmt.Errorf("--diff requires --from-process")
+	}
+	showExtra, _ := cmd.Flags().GetBool("show-extra")
+	if showExtra && !diffFlag {
+		return fmt.Errorf("--show-extra requires --diff")
+	}
+
 	restConfig, err := o.configFlags.ToRESTConfig()
 	if err != nil {
 		return fmt.Errorf("failed to get REST config: %w", err)
@@ -108,82 +193,153 @@ func runExtract(o *Options, cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("invalid arguments, expected TYPE/NAME or TYPE NAME")
 	}
 
-	// Extract based on resource type
-	var envVars []extractor.EnvVar
 	ctx := context.Background()
-
-	switch strings.ToLower(resourceType) {
-	case "deployment", "deploy":
-		deploy, err := clientset.AppsV1().Deployments(namespace).Get(ctx, resourceName, metav1.GetOptions{})
+	workload, selector, err := fetcher.Fetch(ctx, clientset, namespace, resourceType, resourceName)
+	if err != nil {
+		return err
+	}
+	// pod is used to resolve fieldRef/resourceFieldRef values. For workloads it
+	// is a live pod matching the selector, or the pod template if none is found.
+	pod := workload.Pod
+
+	containerName := cmd.Flag("container").Value.String()
+	envVars := extractor.ExtractFromPodSpec(&pod.Spec, containerName)
+	envVars = extractor.WithResource(envVars, workload.Kind, namespace, resourceName)
+	// Inline values that look like secrets are redacted like Secret values
+	envVars = detector.Mark(envVars)
+
+	// livePod is the running pod --from-process reads from
+	var livePod *corev1.Pod
+	if workload.Kind == "Pod" {
+		livePod = workload.Pod
+	}
+	if selector != nil {
+		found, err := fetcher.FindLivePod(ctx, clientset, namespace, selector)
 		if err != nil {
-			return fmt.Errorf("failed to get deployment: %w", err)
+			if _, writeErr := fmt.Fprintf(o.ErrOut, "Warning: failed to find a pod for %s/%s: %v\n", resourceType, resourceName, err); writeErr != nil {
+				return writeErr
+			}
+		} else if found != nil {
+			pod = found
+			livePod = found
 		}
-		envVars = extractor.ExtractFromPodSpec(&deploy.Spec.Template.Spec, cmd.Flag("container").Value.String())
+	}
 
-	case "statefulset", "sts":
-		sts, err := clientset.AppsV1().StatefulSets(namespace).Get(ctx, resourceName, metav1.GetOptions{})
-		if err != nil {
-			return fmt.Errorf("failed to get statefulset: %w", err)
+	// Resolve fieldRef/resourceFieldRef values, honoring explicit overrides
+	envVars = extractor.ResolveFieldRefs(envVars, applyFieldOverrides(cmd, pod))
+
+	// Resolve secrets and configmaps
+	res := resolver.NewFromClientset(clientset, namespace)
+	envVars, err = res.ResolveAll(envVars)
+	if err != nil {
+		var missingErr *resolver.MissingRefsError
+		allowMissing, _ := cmd.Flags().GetBool("allow-missing")
+		if !allowMissing || !errors.As(err, &missingErr) {
+			return fmt.Errorf("failed to resolve references: %w", err)
 		}
-		envVars = extractor.ExtractFromPodSpec(&sts.Spec.Template.Spec, cmd.Flag("container").Value.String())
+		// Missing refs keep their placeholder values
+		if _, writeErr := fmt.Fprintf(o.ErrOut, "Warning: %v\n", err); writeErr != nil {
+			return writeErr
+		}
+	}
 
-	case "daemonset", "ds":
-		ds, err := clientset.AppsV1().DaemonSets(namespace).Get(ctx, resourceName, metav1.GetOptions{})
-		if err != nil {
-			return fmt.Errorf("failed to get daemonset: %w", err)
+	// Expand $(VAR_NAME) references like the kubelet does, before
+	// deduplication removes the values earlier entries refer to
+	envVars = expander.ExpandAll(envVars)
+
+	// Apply the kubelet's precedence rules to duplicate names
+	envVars, shadowings := resolver.Deduplicate(envVars)
+	for _, shadowing := range shadowings {
+		if _, err := fmt.Fprintf(o.ErrOut, "Warning: %s\n", shadowing); err != nil {
+			return err
 		}
-		envVars = extractor.ExtractFromPodSpec(&ds.Spec.Template.Spec, cmd.Flag("container").Value.String())
+	}
 
-	case "pod", "po":
-		pod, err := clientset.CoreV1().Pods(namespace).Get(ctx, resourceName, metav1.GetOptions{})
-		if err != nil {
-			return fmt.Errorf("failed to get pod: %w", err)
+	if processMethod != "" {
+		if livePod == nil {
+			return fmt.Errorf("no running pod found for %s/%s", resourceType, resourceName)
+		}
+		if containerName == "" {
+			containerName = procenv.DefaultContainer(livePod)
 		}
-		envVars = extractor.ExtractFromPodSpec(&pod.Spec, cmd.Flag("container").Value.String())
 
-	case "job":
-		job, err := clientset.BatchV1().Jobs(namespace).Get(ctx, resourceName, metav1.GetOptions{})
-		if err != nil {
-			return fmt.Errorf("failed to get job: %w", err)
+		var specVars []extractor.EnvVar
+		for _, env := range envVars {
+			if env.Origin.Container == containerName {
+				specVars = append(specVars, env)
+			}
 		}
-		envVars = extractor.ExtractFromPodSpec(&job.Spec.Template.Spec, cmd.Flag("container").Value.String())
 
-	case "cronjob", "cj":
-		cj, err := clientset.BatchV1().CronJobs(namespace).Get(ctx, resourceName, metav1.GetOptions{})
+		reader := procenv.NewReader(restConfig, clientset.CoreV1().RESTClient())
+		processVars, err := reader.Read(ctx, livePod, containerName, processMethod)
 		if err != nil {
-			return fmt.Errorf("failed to get cronjob: %w", err)
+			return err
 		}
-		envVars = extractor.ExtractFromPodSpec(&cj.Spec.JobTemplate.Spec.Template.Spec, cmd.Flag("container").Value.String())
+		processVars = extractor.WithResource(processVars, workload.Kind, namespace, resourceName)
+		// Values the spec reads from Secrets stay secret, other values that
+		// look like secrets are detected
+		processVars = detector.Mark(procenv.MatchSpec(processVars, specVars))
 
-	default:
-		return fmt.Errorf("unsupported resource type: %s", resourceType)
+		if diffFlag {
+			return writeProcessDiff(o, specVars, processVars, showExtra)
+		}
+		envVars = processVars
 	}
 
-	// Resolve secrets and configmaps
-	res := resolver.NewFromClientset(clientset, namespace)
-	envVars, err = res.ResolveAll(envVars)
-	if err != nil {
-		if _, writeErr := fmt.Fprintf(o.ErrOut, "Warning: failed to resolve some references: %v\n", err); writeErr != nil {
-			return writeErr
+	// Format output
+	input := formatter.Input{EnvVars: envVars}
+	if format.Scope != formatter.ScopeEnv {
+		input.Workload = &workload
+		if format.Scope == formatter.ScopeContainer || containerName != "" {
+			container, err := workload.FindContainer(containerName)
+			if err != nil {
+				return err
+			}
+			input.Container = container
+		}
+	}
+	if format.Scope == formatter.ScopeContainer {
+		input.EnvVars = nil
+		for _, env := range envVars {
+			if env.Origin.Container == input.Container.Name {
+				input.EnvVars = append(input.EnvVars, env)
+			}
 		}
 	}
 
-	// Format output
-	formatFlag, _ := cmd.Flags().GetString("format")
-	exportFlag, _ := cmd.Flags().GetBool("export")
+	// Redaction applies to every format, formatters write values as they are
+	redactFlag, _ := cmd.Flags().GetBool("redact")
+	redactPolicy, _ := cmd.Flags().GetString("redact-policy")
+	redactSalt, _ := cmd.Flags().GetString("redact-salt")
+	redactNames, _ := cmd.Flags().GetStringArray("redact-name")
+	redactPatterns, _ := cmd.Flags().GetStringArray("redact-pattern")
+	if redactFlag || redactPolicy != "" || len(redactNames) > 0 || len(redactPatterns) > 0 {
+		r, err := redactor.New(redactor.Options{
+			Policy:   redactor.Policy(redactPolicy),
+			Salt:     redactSalt,
+			Names:    redactNames,
+			Patterns: redactPatterns,
+		})
+		if err != nil {
+			return err
+		}
+		if redactSalt == "" && r.Salt() != "" {
+			if _, err := fmt.Fprintf(o.ErrOut, "Hashing with the random salt %s, pass it with --redact-salt to compare fingerprints across runs\n", r.Salt()); err != nil {
+				return err
+			}
+		}
+		input.EnvVars = r.Redact(input.EnvVars)
+	}
 
-	var output string
-	switch formatFlag {
-	case "docker":
-		output = formatter.FormatDocker(envVars, false)
-	case "shell":
-		output = formatter.FormatShell(envVars, exportFlag)
-	case "dotenv":
-		output = formatter.FormatDotenv(envVars)
-	case "compose":
-		output = formatter.FormatCompose(envVars)
-	default:
-		output = formatter.FormatDocker(envVars, false)
+	output, err := format.Formatter.Format(input, formatter.Options{
+		Export:          exportFlag,
+		OutputName:      outputName,
+		OutputNamespace: outputNamespace,
+		Dialect:         dialect,
+		Unset:           unsetFlag,
+	})
+	if err != nil {
+		return err
 	}
 
 	if _, err := fmt.Fprintln(o.Out, output); err != nil {
@@ -191,3 +347,42 @@ func runExtract(o *Options, cmd *cobra.Command, args []string) error {
 	}
 	return nil
 }
+
+// writeProcessDiff writes the differences between the environment derived
+// from the pod spec and the one of the running process
+func writeProcessDiff(o *Options, specVars, processVars []extractor.EnvVar, extra bool) error {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return fmt.Errorf("failed to generate salt: %w", err)
+	}
+	changes := procenv.Compare(specVars, processVars, procenv.CompareOptions{Salt: hex.EncodeToString(salt), Extra: extra})
+	if len(changes) == 0 {
+		_, err := fmt.Fprintln(o.ErrOut, "The process environment matches the pod spec")
+		return err
+	}
+	_, err := fmt.Fprintln(o.Out, differ.FormatUnified(changes, "spec", "process"))
+	return err
+}
+
+// applyFieldOverrides returns a copy of the pod with fields replaced by the
+// values given on the command line
+func applyFieldOverrides(cmd *cobra.Command, pod *corev1.Pod) *corev1.Pod {
+	pod = pod.DeepCopy()
+
+	if v, _ := cmd.Flags().GetString("pod-name"); v != "" {
+		pod.Name = v
+	}
+	if v, _ := cmd.Flags().GetString("pod-ip"); v != "" {
+		pod.Status.PodIP = v
+		pod.Status.PodIPs = []corev1.PodIP{{IP: v}}
+	}
+	if v, _ := cmd.Flags().GetString("host-ip"); v != "" {
+		pod.Status.HostIP = v
+		pod.Status.HostIPs = []corev1.HostIP{{IP: v}}
+	}
+	if v, _ := cmd.Flags().GetString("node-name"); v != "" {
+		pod.Spec.NodeName = v
+	}
+
+	return pod
+}
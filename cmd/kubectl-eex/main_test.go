@@ -0,0 +1,33 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/whywaita/keex/pkg/extractor"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+func TestWriteProcessDiff(t *testing.T) {
+	spec := []extractor.EnvVar{{Name: "LOG_LEVEL", Value: "info", Source: extractor.SourceDirect}}
+	process := []extractor.EnvVar{
+		{Name: "PATH", Value: "/usr/bin", Source: extractor.SourceDirect},
+		{Name: "LOG_LEVEL", Value: "info", Source: extractor.SourceDirect},
+	}
+
+	streams, _, out, errOut := genericclioptions.NewTestIOStreams()
+	if err := writeProcessDiff(&Options{IOStreams: streams}, spec, process, false); err != nil {
+		t.Fatal(err)
+	}
+	if out.Len() != 0 || !strings.Contains(errOut.String(), "matches the pod spec") {
+		t.Errorf("writeProcessDiff() out = %q, err = %q, want a match", out.String(), errOut.String())
+	}
+
+	streams, _, out, _ = genericclioptions.NewTestIOStreams()
+	if err := writeProcessDiff(&Options{IOStreams: streams}, spec, process, true); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "--- spec\n+++ process\n+PATH=/usr/bin\n" {
+		t.Errorf("writeProcessDiff(extra) = %q", out.String())
+	}
+}
@@ -0,0 +1,153 @@
+package detector
+
+import (
+	"math"
+	"regexp"
+	"strings"
+
+	"github.com/whywaita/keex/pkg/expander"
+	"github.com/whywaita/keex/pkg/extractor"
+)
+
+// Finding is an inline env value that looks like a secret
+type Finding struct {
+	EnvVar extractor.EnvVar
+	// Rule is the name of the heuristic that matched
+	Rule string
+	// Reason describes the match without revealing the value
+	Reason string
+}
+
+// rule is a heuristic that reports whether a variable holds a secret
+type rule struct {
+	name   string
+	reason string
+	match  func(name, value string) bool
+}
+
+var (
+	sensitiveName  = regexp.MustCompile(`(?i)(^|_)(PASSWORD|PASSWD|SECRET|TOKEN|KEY|CREDENTIALS?)$`)
+	awsAccessKey   = regexp.MustCompile(`\b(AKIA|ASIA|ABIA|ACCA)[0-9A-Z]{16}\b`)
+	githubToken    = regexp.MustCompile(`\b((ghp|gho|ghu|ghs|ghr)_[A-Za-z0-9]{36}|github_pat_[A-Za-z0-9_]{82})\b`)
+	jwt            = regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+`)
+	pemPrivateKey  = regexp.MustCompile(`-----BEGIN [A-Z0-9 ]*PRIVATE KEY[A-Z ]*-----`)
+	entropyToken   = regexp.MustCompile(`[A-Za-z0-9+/=_-]{24,}`)
+	entropyMinBits = 4.2
+)
+
+// rules are checked in order, well-known formats before the guesses
+var rules = []rule{
+	{
+		name:   "private-key",
+		reason: "PEM private key block",
+		match:  func(_, value string) bool { return pemPrivateKey.MatchString(value) },
+	},
+	{
+		name:   "aws-access-key",
+		reason: "AWS access key ID",
+		match:  func(_, value string) bool { return awsAccessKey.MatchString(value) },
+	},
+	{
+		name:   "github-token",
+		reason: "GitHub token",
+		match:  func(_, value string) bool { return githubToken.MatchString(value) },
+	},
+	{
+		name:   "jwt",
+		reason: "JSON Web Token",
+		match:  func(_, value string) bool { return jwt.MatchString(value) },
+	},
+	{
+		name:   "sensitive-name",
+		reason: "name suggests a password, token, key or secret",
+		match:  func(name, _ string) bool { return sensitiveName.MatchString(name) },
+	},
+	{
+		name:   "high-entropy",
+		reason: "high-entropy string",
+		match:  func(_, value string) bool { return hasHighEntropyToken(value) },
+	},
+}
+
+// Detect reports whether an inline env value looks like a secret. Values from
+// refs, comment entries and values made up only of $(VAR) references are not
+// inline secrets and are never reported.
+func Detect(env extractor.EnvVar) (Finding, bool) {
+	if env.Source != extractor.SourceDirect || env.IsSecret || strings.HasPrefix(env.Name, "#") {
+		return Finding{}, false
+	}
+
+	// References to other variables are not hardcoded
+	literal := expander.Expand(env.Value, func(string) (string, bool) {
+		return " ", true
+	})
+	if strings.TrimSpace(literal) == "" {
+		return Finding{}, false
+	}
+
+	for _, r := range rules {
+		if r.match(env.Name, literal) {
+			return Finding{EnvVar: env, Rule: r.name, Reason: r.reason}, true
+		}
+	}
+	return Finding{}, false
+}
+
+// Scan returns a finding for every inline env value that looks like a secret
+func Scan(envVars []extractor.EnvVar) []Finding {
+	var findings []Finding
+	for _, env := range envVars {
+		if finding, ok := Detect(env); ok {
+			findings = append(findings, finding)
+		}
+	}
+	return findings
+}
+
+// Mark returns a copy of envVars where inline values that look like secrets
+// are marked as IsSecret, so that redaction applies to them
+func Mark(envVars []extractor.EnvVar) []extractor.EnvVar {
+	marked := make([]extractor.EnvVar, len(envVars))
+	for i, env := range envVars {
+		if _, ok := Detect(env); ok {
+			env.IsSecret = true
+		}
+		marked[i] = env
+	}
+	return marked
+}
+
+// hasHighEntropyToken reports whether value contains a long run of token
+// characters with letters, digits and the randomness of a generated secret.
+// Hex strings such as digests and UUIDs stay below the threshold.
+func hasHighEntropyToken(value string) bool {
+	for _, token := range entropyToken.FindAllString(value, -1) {
+		if strings.ContainsAny(token, "0123456789") &&
+			strings.IndexFunc(token, isLetter) >= 0 &&
+			shannonEntropy(token) >= entropyMinBits {
+			return true
+		}
+	}
+	return false
+}
+
+func isLetter(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+}
+
+// shannonEntropy returns the entropy of s in bits per character
+func shannonEntropy(s string) float64 {
+	counts := make(map[rune]int)
+	total := 0
+	for _, r := range s {
+		counts[r]++
+		total++
+	}
+
+	var entropy float64
+	for _, count := range counts {
+		p := float64(count) / float64(total)
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
@@ -0,0 +1,243 @@
+package differ
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/whywaita/keex/pkg/extractor"
+	"github.com/whywaita/keex/pkg/formatter"
+	"github.com/whywaita/keex/pkg/redactor"
+)
+
+// ChangeType is how a variable differs between two environments
+type ChangeType string
+
+const (
+	Added   ChangeType = "added"
+	Removed ChangeType = "removed"
+	Changed ChangeType = "changed"
+)
+
+// Change is a variable that differs between two environments
+type Change struct {
+	Name string
+	Type ChangeType
+	// Old and New are the values on each side, empty on the side where the
+	// variable is missing. Secrets are replaced by salted fingerprints.
+	Old string
+	New string
+	// OldSource and NewSource tell where the value came from on each side
+	OldSource string
+	NewSource string
+	// Secret is set when the value is a secret on either side
+	Secret bool
+}
+
+// Options configures Diff
+type Options struct {
+	// Salt is prepended to secret values before they are hashed
+	Salt string
+}
+
+// Diff compares two environments by variable name and returns the changes
+// from one to the other, sorted by name. A value that is a secret on either
+// side is compared and reported by its fingerprint only. Comment entries are
+// ignored; when a name appears several times the last value wins.
+func Diff(from, to []extractor.EnvVar, opts Options) []Change {
+	hasher, _ := redactor.New(redactor.Options{Policy: redactor.PolicyHash, Salt: opts.Salt})
+
+	fromVars, toVars := byName(from), byName(to)
+	names := make(map[string]bool, len(fromVars)+len(toVars))
+	for name := range fromVars {
+		names[name] = true
+	}
+	for name := range toVars {
+		names[name] = true
+	}
+
+	var changes []Change
+	for name := range names {
+		old, inFrom := fromVars[name]
+		updated, inTo := toVars[name]
+
+		change := Change{Name: name, Secret: old.IsSecret || updated.IsSecret}
+		oldValue, newValue := old.Value, updated.Value
+		if change.Secret {
+			oldValue, newValue = hasher.Mask(oldValue), hasher.Mask(newValue)
+		}
+
+		switch {
+		case !inFrom:
+			change.Type = Added
+			change.New, change.NewSource = newValue, updated.Source.String()
+		case !inTo:
+			change.Type = Removed
+			change.Old, change.OldSource = oldValue, old.Source.String()
+		case oldValue != newValue:
+			change.Type = Changed
+			change.Old, change.OldSource = oldValue, old.Source.String()
+			change.New, change.NewSource = newValue, updated.Source.String()
+		default:
+			continue
+		}
+		changes = append(changes, change)
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Name < changes[j].Name
+	})
+	return changes
+}
+
+// byName indexes the env vars by name, skipping comment entries
+func byName(envVars []extractor.EnvVar) map[string]extractor.EnvVar {
+	indexed := make(map[string]extractor.EnvVar, len(envVars))
+	for _, env := range envVars {
+		if !strings.HasPrefix(env.Name, "#") {
+			indexed[env.Name] = env
+		}
+	}
+	return indexed
+}
+
+// Outputs lists the supported output formats
+var Outputs = []string{"unified", "table", "json"}
+
+// Format renders the changes in one of Outputs. from and to label the two
+// environments.
+func Format(changes []Change, output, from, to string) (string, error) {
+	switch output {
+	case "unified":
+		return FormatUnified(changes, from, to), nil
+	case "table":
+		return FormatTable(changes), nil
+	case "json":
+		return FormatJSON(changes, from, to)
+	default:
+		return "", fmt.Errorf("invalid output: %s (must be one of %s)", output, strings.Join(Outputs, ", "))
+	}
+}
+
+// FormatUnified writes the changes like diff -u, with a - line for the old
+// value and a + line for the new one. It returns an empty string when there
+// are no changes.
+func FormatUnified(changes []Change, from, to string) string {
+	if len(changes) == 0 {
+		return ""
+	}
+
+	lines := []string{"--- " + from, "+++ " + to}
+	for _, change := range changes {
+		if change.Type != Added {
+			lines = append(lines, "-"+change.Name+"="+displayValue(change.Old))
+		}
+		if change.Type != Removed {
+			lines = append(lines, "+"+change.Name+"="+displayValue(change.New))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// FormatTable writes the changes as aligned columns. It returns an empty
+// string when there are no changes.
+func FormatTable(changes []Change) string {
+	if len(changes) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "CHANGE\tNAME\tOLD\tNEW\tSOURCE")
+	for _, change := range changes {
+		// - marks a missing value, "" an empty one
+		old, updated := "-", "-"
+		if change.Type != Added {
+			old = tableValue(change.Old)
+		}
+		if change.Type != Removed {
+			updated = tableValue(change.New)
+		}
+		source := change.NewSource
+		switch {
+		case change.Type == Removed:
+			source = change.OldSource
+		case change.Type == Changed && change.OldSource != change.NewSource:
+			source = change.OldSource + " -> " + change.NewSource
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", change.Type, change.Name, old, updated, source)
+	}
+	_ = w.Flush()
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// tableValue is displayValue with empty values visible
+func tableValue(value string) string {
+	if value == "" {
+		return `""`
+	}
+	return displayValue(value)
+}
+
+// displayValue quotes values that would not be readable on one line
+func displayValue(value string) string {
+	if strings.ContainsAny(value, "\n\r\t") || value != strings.TrimSpace(value) {
+		return strconv.Quote(value)
+	}
+	return value
+}
+
+// Report is the document FormatJSON writes
+type Report struct {
+	SchemaVersion string        `json:"schemaVersion"`
+	From          string        `json:"from"`
+	To            string        `json:"to"`
+	Changes       []ChangeEntry `json:"changes"`
+}
+
+// ChangeEntry is a change in a Report
+type ChangeEntry struct {
+	Name      string     `json:"name"`
+	Change    ChangeType `json:"change"`
+	Old       *string    `json:"old,omitempty"`
+	New       *string    `json:"new,omitempty"`
+	OldSource string     `json:"oldSource,omitempty"`
+	NewSource string     `json:"newSource,omitempty"`
+	Secret    bool       `json:"secret,omitempty"`
+}
+
+// FormatJSON writes the changes as a versioned json document
+func FormatJSON(changes []Change, from, to string) (string, error) {
+	report := Report{
+		SchemaVersion: formatter.SchemaVersion,
+		From:          from,
+		To:            to,
+		Changes:       make([]ChangeEntry, 0, len(changes)),
+	}
+	for _, change := range changes {
+		entry := ChangeEntry{
+			Name:      change.Name,
+			Change:    change.Type,
+			OldSource: change.OldSource,
+			NewSource: change.NewSource,
+			Secret:    change.Secret,
+		}
+		// Empty values are kept, missing ones are omitted
+		if change.Type != Added {
+			entry.Old = &change.Old
+		}
+		if change.Type != Removed {
+			entry.New = &change.New
+		}
+		report.Changes = append(report.Changes, entry)
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode json: %w", err)
+	}
+	return string(data), nil
+}
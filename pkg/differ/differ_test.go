@@ -0,0 +1,122 @@
+package differ
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/whywaita/keex/pkg/extractor"
+)
+
+func testEnvironments() (from, to []extractor.EnvVar) {
+	from = []extractor.EnvVar{
+		{Name: "LOG_LEVEL", Value: "info", Source: extractor.SourceDirect},
+		{Name: "REGION", Value: "eu-west-1", Source: extractor.SourceConfigMap},
+		{Name: "DB_PASSWORD", Value: "staging-pw", Source: extractor.SourceSecret, IsSecret: true},
+		{Name: "API_KEY", Value: "same", Source: extractor.SourceSecret, IsSecret: true},
+		{Name: "LEGACY", Value: "", Source: extractor.SourceDirect},
+		{Name: "# from secret: extra", Source: extractor.SourceSecret},
+	}
+	to = []extractor.EnvVar{
+		{Name: "LOG_LEVEL", Value: "warn", Source: extractor.SourceDirect},
+		{Name: "REGION", Value: "eu-west-1", Source: extractor.SourceDirect},
+		{Name: "DB_PASSWORD", Value: "prod-pw", Source: extractor.SourceSecret, IsSecret: true},
+		{Name: "API_KEY", Value: "same", Source: extractor.SourceDirect},
+		{Name: "FEATURE_X", Value: "on", Source: extractor.SourceDirect},
+	}
+	return from, to
+}
+
+func TestDiff(t *testing.T) {
+	from, to := testEnvironments()
+	changes := Diff(from, to, Options{Salt: "pepper"})
+
+	expected := []struct {
+		name   string
+		change ChangeType
+		secret bool
+	}{
+		{name: "DB_PASSWORD", change: Changed, secret: true},
+		{name: "FEATURE_X", change: Added},
+		{name: "LEGACY", change: Removed},
+		{name: "LOG_LEVEL", change: Changed},
+	}
+	if len(changes) != len(expected) {
+		t.Fatalf("Diff() = %+v, want %d changes", changes, len(expected))
+	}
+	for i, want := range expected {
+		got := changes[i]
+		if got.Name != want.name || got.Type != want.change || got.Secret != want.secret {
+			t.Errorf("Diff()[%d] = %s %s secret=%v, want %s %s secret=%v", i, got.Name, got.Type, got.Secret, want.name, want.change, want.secret)
+		}
+	}
+
+	secret := changes[0]
+	if strings.Contains(secret.Old+secret.New, "-pw") || !strings.HasPrefix(secret.Old, "sha256:") || secret.Old == secret.New {
+		t.Errorf("Diff() secret values = %q, %q, want distinct fingerprints", secret.Old, secret.New)
+	}
+	if other := Diff(from, to, Options{Salt: "salt"}); other[0].Old == secret.Old {
+		t.Error("Diff() fingerprints do not depend on the salt")
+	}
+	if changes[3].Old != "info" || changes[3].New != "warn" || changes[1].NewSource != "direct" {
+		t.Errorf("Diff() = %+v", changes)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	changes := []Change{
+		{Name: "FEATURE_X", Type: Added, New: "on", NewSource: "direct"},
+		{Name: "LEGACY", Type: Removed, Old: "", OldSource: "direct"},
+		{Name: "LOG_LEVEL", Type: Changed, Old: "info", New: "line\nbreak", OldSource: "configMap", NewSource: "direct"},
+	}
+
+	unified, err := Format(changes, "unified", "staging.yaml", "prod.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `--- staging.yaml
++++ prod.yaml
++FEATURE_X=on
+-LEGACY=
+-LOG_LEVEL=info
++LOG_LEVEL="line\nbreak"`
+	if unified != expected {
+		t.Errorf("FormatUnified() =\n%s\nwant\n%s", unified, expected)
+	}
+
+	table, err := Format(changes, "table", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = `CHANGE   NAME       OLD   NEW            SOURCE
+added    FEATURE_X  -     on             direct
+removed  LEGACY     ""    -              direct
+changed  LOG_LEVEL  info  "line\nbreak"  configMap -> direct`
+	if table != expected {
+		t.Errorf("FormatTable() =\n%s\nwant\n%s", table, expected)
+	}
+
+	output, err := Format(changes, "json", "staging.yaml", "prod.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report Report
+	if err := json.Unmarshal([]byte(output), &report); err != nil {
+		t.Fatalf("FormatJSON() produced invalid json: %v", err)
+	}
+	if report.SchemaVersion != "keex/v1" || report.From != "staging.yaml" || len(report.Changes) != 3 {
+		t.Errorf("FormatJSON() = %s", output)
+	}
+	if report.Changes[0].Old != nil || report.Changes[1].Old == nil || *report.Changes[1].Old != "" || report.Changes[1].New != nil {
+		t.Errorf("FormatJSON() must omit missing values and keep empty ones:\n%s", output)
+	}
+
+	if _, err := Format(changes, "html", "", ""); err == nil {
+		t.Error("Format() expected error for unknown output")
+	}
+	for _, output := range []string{"unified", "table"} {
+		if empty, _ := Format(nil, output, "a", "b"); empty != "" {
+			t.Errorf("Format(%s) without changes = %q, want empty", output, empty)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package dotenv
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/whywaita/keex/pkg/extractor"
+)
+
+// Parse reads a .env file into direct env vars. It accepts what docker
+// compose and godotenv accept: KEY=value lines with an optional export prefix,
+// # comments, double-quoted values with backslash escapes that may span lines,
+// and literal single-quoted values. $$ and \$ are read as $; other references
+// are kept as written.
+func Parse(reader io.Reader) ([]extractor.EnvVar, error) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read env file: %w", err)
+	}
+
+	p := &parser{input: strings.ReplaceAll(string(data), "\r\n", "\n"), line: 1}
+	var envVars []extractor.EnvVar
+	for {
+		p.skipBlankAndComments()
+		if p.done() {
+			return envVars, nil
+		}
+		env, err := p.assignment()
+		if err != nil {
+			return nil, err
+		}
+		envVars = append(envVars, env)
+	}
+}
+
+type parser struct {
+	input string
+	pos   int
+	line  int
+}
+
+func (p *parser) done() bool {
+	return p.pos >= len(p.input)
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %s", p.line, fmt.Sprintf(format, args...))
+}
+
+// skipBlankAndComments moves to the start of the next assignment
+func (p *parser) skipBlankAndComments() {
+	for !p.done() {
+		switch p.input[p.pos] {
+		case '\n':
+			p.line++
+			p.pos++
+		case ' ', '\t':
+			p.pos++
+		case '#':
+			p.skipLine()
+		default:
+			return
+		}
+	}
+}
+
+// skipLine moves past the next line break
+func (p *parser) skipLine() {
+	end := strings.IndexByte(p.input[p.pos:], '\n')
+	if end < 0 {
+		p.pos = len(p.input)
+		return
+	}
+	p.pos += end + 1
+	p.line++
+}
+
+// assignment reads KEY=value and the rest of its line
+func (p *parser) assignment() (extractor.EnvVar, error) {
+	rest := p.input[p.pos:]
+	if after, ok := strings.CutPrefix(rest, "export "); ok {
+		p.pos += len(rest) - len(strings.TrimLeft(after, " \t"))
+		rest = p.input[p.pos:]
+	}
+
+	end := strings.IndexAny(rest, "=\n")
+	if end < 0 || rest[end] != '=' {
+		return extractor.EnvVar{}, p.errorf("expected KEY=value")
+	}
+	name := strings.TrimRight(rest[:end], " \t")
+	if name == "" || strings.ContainsAny(name, " \t") {
+		return extractor.EnvVar{}, p.errorf("invalid variable name %q", name)
+	}
+	p.pos += end + 1
+	for !p.done() && (p.input[p.pos] == ' ' || p.input[p.pos] == '\t') {
+		p.pos++
+	}
+
+	var value string
+	var err error
+	switch {
+	case p.done():
+	case p.input[p.pos] == '"':
+		value, err = p.doubleQuoted()
+	case p.input[p.pos] == '\'':
+		value, err = p.singleQuoted()
+	default:
+		value = p.unquoted()
+	}
+	if err != nil {
+		return extractor.EnvVar{}, err
+	}
+
+	// Only a comment may follow a quoted value
+	if trailing := strings.TrimLeft(p.restOfLine(), " \t"); trailing != "" && trailing[0] != '#' {
+		return extractor.EnvVar{}, p.errorf("unexpected %q after value of %s", trailing, name)
+	}
+	p.skipLine()
+
+	return extractor.EnvVar{Name: name, Value: value, Source: extractor.SourceDirect}, nil
+}
+
+// restOfLine returns the input up to the next line break
+func (p *parser) restOfLine() string {
+	rest := p.input[p.pos:]
+	if end := strings.IndexByte(rest, '\n'); end >= 0 {
+		return rest[:end]
+	}
+	return rest
+}
+
+// unquoted reads a value up to the end of the line or an inline comment
+func (p *parser) unquoted() string {
+	line := p.restOfLine()
+	value := line
+	for i := 1; i < len(line); i++ {
+		if line[i] == '#' && (line[i-1] == ' ' || line[i-1] == '\t') {
+			value = line[:i]
+			break
+		}
+	}
+	p.pos += len(value)
+	return strings.ReplaceAll(strings.TrimRight(value, " \t"), "$$", "$")
+}
+
+// doubleQuoted reads a double-quoted value with backslash escapes
+func (p *parser) doubleQuoted() (string, error) {
+	start := p.line
+	p.pos++
+	var b strings.Builder
+	for !p.done() {
+		c := p.input[p.pos]
+		switch {
+		case c == '"':
+			p.pos++
+			return b.String(), nil
+		case c == '\\' && p.pos+1 < len(p.input):
+			p.pos++
+			switch escaped := p.input[p.pos]; escaped {
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case '\\', '"', '$':
+				b.WriteByte(escaped)
+			default:
+				b.WriteByte('\\')
+				b.WriteByte(escaped)
+			}
+		case strings.HasPrefix(p.input[p.pos:], "$$"):
+			b.WriteByte('$')
+			p.pos++
+		default:
+			if c == '\n' {
+				p.line++
+			}
+			b.WriteByte(c)
+		}
+		p.pos++
+	}
+	return "", fmt.Errorf("line %d: unterminated double quote", start)
+}
+
+// singleQuoted reads a literal single-quoted value
+func (p *parser) singleQuoted() (string, error) {
+	start := p.line
+	end := strings.IndexByte(p.input[p.pos+1:], '\'')
+	if end < 0 {
+		return "", fmt.Errorf("line %d: unterminated single quote", start)
+	}
+	value := p.input[p.pos+1 : p.pos+1+end]
+	p.line += strings.Count(value, "\n")
+	p.pos += end + 2
+	return value, nil
+}
@@ -0,0 +1,105 @@
+package dotenv
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/whywaita/keex/pkg/extractor"
+	"github.com/whywaita/keex/pkg/formatter"
+)
+
+func TestParse(t *testing.T) {
+	input := `# comment
+PLAIN=value
+  SPACED = padded value
+export EXPORTED=yes
+EMPTY=
+INLINE_COMMENT=value # comment
+HASH=a#b
+DOUBLE="say \"hi\"\n$$HOME \$USER"
+SINGLE='literal \n $HOME'
+MULTILINE="first
+second"
+QUOTED_COMMENT="value" # comment
+CRLF=windows` + "\r\n"
+
+	expected := []extractor.EnvVar{
+		{Name: "PLAIN", Value: "value"},
+		{Name: "SPACED", Value: "padded value"},
+		{Name: "EXPORTED", Value: "yes"},
+		{Name: "EMPTY", Value: ""},
+		{Name: "INLINE_COMMENT", Value: "value"},
+		{Name: "HASH", Value: "a#b"},
+		{Name: "DOUBLE", Value: "say \"hi\"\n$HOME $USER"},
+		{Name: "SINGLE", Value: `literal \n $HOME`},
+		{Name: "MULTILINE", Value: "first\nsecond"},
+		{Name: "QUOTED_COMMENT", Value: "value"},
+		{Name: "CRLF", Value: "windows"},
+	}
+
+	envVars, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if len(envVars) != len(expected) {
+		t.Fatalf("Parse() = %+v, want %d variables", envVars, len(expected))
+	}
+	for i, want := range expected {
+		if envVars[i].Name != want.Name || envVars[i].Value != want.Value || envVars[i].Source != extractor.SourceDirect {
+			t.Errorf("Parse()[%d] = %s=%q, want %s=%q", i, envVars[i].Name, envVars[i].Value, want.Name, want.Value)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := map[string]string{
+		"missing equals":        "FOO\n",
+		"invalid name":          "FOO BAR=1\n",
+		"unterminated double":   "FOO=\"value\n",
+		"unterminated single":   "FOO='value\n",
+		"text after the quotes": "FOO=\"a\"b\n",
+	}
+	for name, input := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := Parse(strings.NewReader(input)); err == nil {
+				t.Errorf("Parse(%q) expected error", input)
+			}
+		})
+	}
+}
+
+func TestParseFormatDotenv(t *testing.T) {
+	values := map[string]string{
+		"DOLLAR":    "$HOME ${USER} $$ $",
+		"QUOTES":    `say "hi" it's`,
+		"BACKSLASH": `C:\path\to\n`,
+		"MULTILINE": "first\n\n  indented\r\nlast\n",
+		"HASH":      "value # not a comment",
+	}
+	var envVars []extractor.EnvVar
+	for name, value := range values {
+		envVars = append(envVars, extractor.EnvVar{Name: name, Value: value, Source: extractor.SourceDirect})
+	}
+
+	// Files written by keex read back unchanged
+	for _, dialect := range []formatter.Dialect{formatter.DialectCompose, formatter.DialectGodotenv} {
+		t.Run(string(dialect), func(t *testing.T) {
+			output, err := formatter.FormatDotenv(envVars, dialect)
+			if err != nil {
+				t.Fatal(err)
+			}
+			parsed, err := Parse(strings.NewReader(output))
+			if err != nil {
+				t.Fatalf("Parse() error = %v\n%s", err, output)
+			}
+			if len(parsed) != len(values) {
+				t.Fatalf("Parse() read %d variables, want %d", len(parsed), len(values))
+			}
+			for _, env := range parsed {
+				if env.Value != values[env.Name] {
+					t.Errorf("%s = %q, want %q", env.Name, env.Value, values[env.Name])
+				}
+			}
+		})
+	}
+}
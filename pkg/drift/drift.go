@@ -0,0 +1,452 @@
+package drift
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/whywaita/keex/pkg/detector"
+	"github.com/whywaita/keex/pkg/differ"
+	"github.com/whywaita/keex/pkg/extractor"
+	"github.com/whywaita/keex/pkg/formatter"
+	"github.com/whywaita/keex/pkg/redactor"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Layer is where a manifest and the live workload disagree
+type Layer string
+
+const (
+	// LayerSpec is an env or envFrom entry, or a container, that differs
+	// between the manifest and the live workload
+	LayerSpec Layer = "spec"
+	// LayerValue is a resolved value that differs although its entry is the
+	// same, because the referenced Secret or ConfigMap differs
+	LayerValue Layer = "value"
+	// LayerRollout is a referenced Secret or ConfigMap that was modified after
+	// the running pod started, so the pod still uses the old values
+	LayerRollout Layer = "rollout"
+)
+
+// Drift is a difference between a manifest and the live workload. Change is
+// seen from the manifest: added means only the live workload has it.
+type Drift struct {
+	Layer     Layer
+	Change    differ.ChangeType
+	Container string
+	Name      string
+	// Manifest and Live describe each side, empty on the side that lacks it
+	Manifest string
+	Live     string
+	// Detail tells where the value comes from or why it drifted
+	Detail string
+}
+
+// Options configures the comparisons
+type Options struct {
+	// Salt is prepended to secret values before they are hashed
+	Salt string
+}
+
+// CompareSpecs compares the containers of two pod specs and their env and
+// envFrom entries. Inline values that look like secrets are compared by
+// fingerprint.
+func CompareSpecs(manifest, live *corev1.PodSpec, opts Options) []Drift {
+	hasher, _ := redactor.New(redactor.Options{Policy: redactor.PolicyHash, Salt: opts.Salt})
+	manifestContainers, liveContainers := containersByName(manifest), containersByName(live)
+
+	var drifts []Drift
+	for _, name := range unionKeys(manifestContainers, liveContainers) {
+		m, inManifest := manifestContainers[name]
+		l, inLive := liveContainers[name]
+		switch {
+		case !inManifest:
+			drifts = append(drifts, Drift{Layer: LayerSpec, Change: differ.Added, Container: name, Detail: "container"})
+			continue
+		case !inLive:
+			drifts = append(drifts, Drift{Layer: LayerSpec, Change: differ.Removed, Container: name, Detail: "container"})
+			continue
+		}
+
+		manifestEntries, liveEntries := envEntries(m, hasher), envEntries(l, hasher)
+		for _, key := range unionKeys(manifestEntries, liveEntries) {
+			me, inManifest := manifestEntries[key]
+			le, inLive := liveEntries[key]
+			drift := Drift{Layer: LayerSpec, Container: name, Name: key, Manifest: me, Live: le}
+			switch {
+			case !inManifest:
+				drift.Change = differ.Added
+			case !inLive:
+				drift.Change = differ.Removed
+			case me != le:
+				drift.Change = differ.Changed
+			default:
+				continue
+			}
+			drifts = append(drifts, drift)
+		}
+	}
+	return drifts
+}
+
+// CompareValues compares the resolved env vars of the manifest and the live
+// workload per container. Variables whose env entry differs are left to
+// CompareSpecs and skipped; what remains is caused by the referenced Secrets
+// and ConfigMaps. Secrets are compared by fingerprint.
+func CompareValues(manifest, live []extractor.EnvVar, specDrifts []Drift, opts Options) []Drift {
+	explained := make(map[string]bool)
+	for _, drift := range specDrifts {
+		explained[drift.Container+"/"+drift.Name] = true
+		if drift.Name == "" {
+			// The whole container is missing on one side
+			explained[drift.Container+"/"] = true
+		}
+	}
+
+	manifestVars, liveVars := varsByContainer(manifest), varsByContainer(live)
+	var drifts []Drift
+	for _, container := range unionKeys(manifestVars, liveVars) {
+		if explained[container+"/"] {
+			continue
+		}
+		sources := make(map[string]string)
+		// A variable is explained when the entry that defines it on either
+		// side, an env entry or an envFrom source, drifted
+		entryDrifted := make(map[string]bool)
+		for _, env := range append(append([]extractor.EnvVar(nil), manifestVars[container]...), liveVars[container]...) {
+			sources[env.Name] = describeSource(env)
+			if explained[container+"/"+entryKey(env)] {
+				entryDrifted[env.Name] = true
+			}
+		}
+
+		for _, change := range differ.Diff(manifestVars[container], liveVars[container], differ.Options{Salt: opts.Salt}) {
+			if entryDrifted[change.Name] {
+				continue
+			}
+			drifts = append(drifts, Drift{
+				Layer:     LayerValue,
+				Change:    change.Type,
+				Container: container,
+				Name:      change.Name,
+				Manifest:  change.Old,
+				Live:      change.New,
+				Detail:    sources[change.Name],
+			})
+		}
+	}
+	return drifts
+}
+
+// Object is a Secret or ConfigMap referenced by the live workload
+type Object struct {
+	Kind     string
+	Name     string
+	Modified time.Time
+}
+
+// LastModified returns the time of the latest write to an object recorded in
+// its managed fields, or its creation time
+func LastModified(object metav1.Object) time.Time {
+	modified := object.GetCreationTimestamp().Time
+	for _, entry := range object.GetManagedFields() {
+		if entry.Time != nil && entry.Time.After(modified) {
+			modified = entry.Time.Time
+		}
+	}
+	return modified
+}
+
+// CompareRollout reports the objects that were modified after the pod
+// started. Env vars are read when a container starts, so the running pod
+// keeps the values from before the change until it is restarted.
+func CompareRollout(pod *corev1.Pod, objects []Object) []Drift {
+	if pod == nil || pod.Status.StartTime == nil {
+		return nil
+	}
+	started := pod.Status.StartTime.Time
+
+	var drifts []Drift
+	for _, object := range objects {
+		if !object.Modified.After(started) {
+			continue
+		}
+		drifts = append(drifts, Drift{
+			Layer:  LayerRollout,
+			Change: differ.Changed,
+			Name:   object.Kind + " " + object.Name,
+			Detail: fmt.Sprintf("modified at %s, after pod %s started at %s; restart the workload to apply it",
+				object.Modified.UTC().Format(time.RFC3339), pod.Name, started.UTC().Format(time.RFC3339)),
+		})
+	}
+	return drifts
+}
+
+// ReferencedObjects returns the Secrets and ConfigMaps the env and envFrom
+// entries of the pod spec refer to, as Object values without a time
+func ReferencedObjects(spec *corev1.PodSpec) []Object {
+	seen := make(map[Object]bool)
+	var objects []Object
+	add := func(kind, name string) {
+		object := Object{Kind: kind, Name: name}
+		if !seen[object] {
+			seen[object] = true
+			objects = append(objects, object)
+		}
+	}
+
+	for _, container := range allContainers(spec) {
+		for _, env := range container.Env {
+			if env.ValueFrom == nil {
+				continue
+			}
+			if ref := env.ValueFrom.SecretKeyRef; ref != nil {
+				add("secret", ref.Name)
+			}
+			if ref := env.ValueFrom.ConfigMapKeyRef; ref != nil {
+				add("configMap", ref.Name)
+			}
+		}
+		for _, envFrom := range container.EnvFrom {
+			if envFrom.SecretRef != nil {
+				add("secret", envFrom.SecretRef.Name)
+			}
+			if envFrom.ConfigMapRef != nil {
+				add("configMap", envFrom.ConfigMapRef.Name)
+			}
+		}
+	}
+	return objects
+}
+
+// envEntries describes the env and envFrom entries of a container, keyed by
+// "env NAME" and "envFrom KIND NAME"
+func envEntries(container corev1.Container, hasher *redactor.Redactor) map[string]string {
+	entries := make(map[string]string)
+	for _, env := range container.Env {
+		key := "env " + env.Name
+		if _, ok := entries[key]; ok {
+			continue
+		}
+		switch {
+		case env.ValueFrom == nil:
+			value := env.Value
+			if _, secret := detector.Detect(extractor.EnvVar{Name: env.Name, Value: value, Source: extractor.SourceDirect}); secret {
+				value = hasher.Mask(value)
+			}
+			entries[key] = fmt.Sprintf("value %q", value)
+		case env.ValueFrom.SecretKeyRef != nil:
+			ref := env.ValueFrom.SecretKeyRef
+			entries[key] = withOptional("secretKeyRef "+ref.Name+"/"+ref.Key, ref.Optional)
+		case env.ValueFrom.ConfigMapKeyRef != nil:
+			ref := env.ValueFrom.ConfigMapKeyRef
+			entries[key] = withOptional("configMapKeyRef "+ref.Name+"/"+ref.Key, ref.Optional)
+		case env.ValueFrom.FieldRef != nil:
+			entries[key] = "fieldRef " + env.ValueFrom.FieldRef.FieldPath
+		case env.ValueFrom.ResourceFieldRef != nil:
+			ref := env.ValueFrom.ResourceFieldRef
+			entries[key] = "resourceFieldRef " + ref.Resource
+			if ref.ContainerName != "" {
+				entries[key] += " of " + ref.ContainerName
+			}
+			if !ref.Divisor.IsZero() {
+				entries[key] += " / " + ref.Divisor.String()
+			}
+		}
+	}
+
+	for _, envFrom := range container.EnvFrom {
+		var key, value string
+		switch {
+		case envFrom.SecretRef != nil:
+			key = "envFrom secret " + envFrom.SecretRef.Name
+			value = withOptional("secretRef "+envFrom.SecretRef.Name, envFrom.SecretRef.Optional)
+		case envFrom.ConfigMapRef != nil:
+			key = "envFrom configMap " + envFrom.ConfigMapRef.Name
+			value = withOptional("configMapRef "+envFrom.ConfigMapRef.Name, envFrom.ConfigMapRef.Optional)
+		default:
+			continue
+		}
+		if envFrom.Prefix != "" {
+			value += " prefix " + envFrom.Prefix
+		}
+		entries[key] = value
+	}
+	return entries
+}
+
+func withOptional(description string, optional *bool) string {
+	if optional != nil && *optional {
+		return description + " (optional)"
+	}
+	return description
+}
+
+// entryKey returns the envEntries key of the entry that defines a resolved
+// variable
+func entryKey(env extractor.EnvVar) string {
+	switch {
+	case env.EnvFrom && env.SecretRef != nil:
+		return "envFrom secret " + env.SecretRef.Name
+	case env.EnvFrom && env.ConfigRef != nil:
+		return "envFrom configMap " + env.ConfigRef.Name
+	default:
+		return "env " + env.Name
+	}
+}
+
+// describeSource tells where a resolved value comes from
+func describeSource(env extractor.EnvVar) string {
+	var source string
+	switch {
+	case env.SecretRef != nil:
+		source = "secret " + env.SecretRef.Name + " key " + env.SecretRef.Key
+	case env.ConfigRef != nil:
+		source = "configMap " + env.ConfigRef.Name + " key " + env.ConfigRef.Key
+	default:
+		source = env.Source.String()
+	}
+	if env.EnvFrom {
+		source += " (envFrom)"
+	}
+	return source
+}
+
+func allContainers(spec *corev1.PodSpec) []corev1.Container {
+	return append(append([]corev1.Container(nil), spec.InitContainers...), spec.Containers...)
+}
+
+func containersByName(spec *corev1.PodSpec) map[string]corev1.Container {
+	containers := make(map[string]corev1.Container)
+	for _, container := range allContainers(spec) {
+		containers[container.Name] = container
+	}
+	return containers
+}
+
+func varsByContainer(envVars []extractor.EnvVar) map[string][]extractor.EnvVar {
+	byContainer := make(map[string][]extractor.EnvVar)
+	for _, env := range envVars {
+		byContainer[env.Origin.Container] = append(byContainer[env.Origin.Container], env)
+	}
+	return byContainer
+}
+
+// unionKeys returns the keys of both maps, sorted
+func unionKeys[V1, V2 any](a map[string]V1, b map[string]V2) []string {
+	seen := make(map[string]bool, len(a)+len(b))
+	var keys []string
+	for key := range a {
+		seen[key] = true
+		keys = append(keys, key)
+	}
+	for key := range b {
+		if !seen[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Outputs lists the supported output formats
+var Outputs = []string{"table", "json"}
+
+// Format renders the drifts in one of Outputs. manifest and live label the
+// two sides.
+func Format(drifts []Drift, output, manifest, live string) (string, error) {
+	switch output {
+	case "table":
+		return FormatTable(drifts), nil
+	case "json":
+		return FormatJSON(drifts, manifest, live)
+	default:
+		return "", fmt.Errorf("invalid output: %s (must be one of %s)", output, strings.Join(Outputs, ", "))
+	}
+}
+
+// FormatTable writes the drifts as aligned columns. It returns an empty
+// string when there is no drift.
+func FormatTable(drifts []Drift) string {
+	if len(drifts) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "LAYER\tCHANGE\tCONTAINER\tNAME\tMANIFEST\tLIVE\tDETAIL")
+	for _, drift := range drifts {
+		// - marks a missing value, "" an empty one
+		manifest, live := "-", "-"
+		if drift.Layer != LayerRollout {
+			if drift.Change != differ.Added {
+				manifest = tableValue(drift.Manifest)
+			}
+			if drift.Change != differ.Removed {
+				live = tableValue(drift.Live)
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", drift.Layer, drift.Change, orDash(drift.Container), orDash(drift.Name), manifest, live, orDash(drift.Detail))
+	}
+	_ = w.Flush()
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// tableValue quotes values that would not be readable on one line and makes
+// empty ones visible
+func tableValue(value string) string {
+	if value == "" || strings.ContainsAny(value, "\n\r\t") || value != strings.TrimSpace(value) {
+		return strconv.Quote(value)
+	}
+	return value
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+// Report is the document FormatJSON writes
+type Report struct {
+	SchemaVersion string       `json:"schemaVersion"`
+	Manifest      string       `json:"manifest"`
+	Live          string       `json:"live"`
+	Drifts        []DriftEntry `json:"drifts"`
+}
+
+// DriftEntry is a drift in a Report
+type DriftEntry struct {
+	Layer     Layer             `json:"layer"`
+	Change    differ.ChangeType `json:"change"`
+	Container string            `json:"container,omitempty"`
+	Name      string            `json:"name,omitempty"`
+	Manifest  string            `json:"manifest,omitempty"`
+	Live      string            `json:"live,omitempty"`
+	Detail    string            `json:"detail,omitempty"`
+}
+
+// FormatJSON writes the drifts as a versioned json document
+func FormatJSON(drifts []Drift, manifest, live string) (string, error) {
+	report := Report{
+		SchemaVersion: formatter.SchemaVersion,
+		Manifest:      manifest,
+		Live:          live,
+		Drifts:        make([]DriftEntry, 0, len(drifts)),
+	}
+	for _, drift := range drifts {
+		report.Drifts = append(report.Drifts, DriftEntry(drift))
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode json: %w", err)
+	}
+	return string(data), nil
+}
@@ -0,0 +1,228 @@
+package drift
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/whywaita/keex/pkg/differ"
+	"github.com/whywaita/keex/pkg/extractor"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func secretKeyRef(name, key string) *corev1.EnvVarSource {
+	return &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+		LocalObjectReference: corev1.LocalObjectReference{Name: name}, Key: key,
+	}}
+}
+
+func TestCompareSpecs(t *testing.T) {
+	manifest := &corev1.PodSpec{
+		InitContainers: []corev1.Container{{Name: "migrate"}},
+		Containers: []corev1.Container{{
+			Name: "app",
+			Env: []corev1.EnvVar{
+				{Name: "LOG_LEVEL", Value: "info"},
+				{Name: "DB_PASSWORD", ValueFrom: secretKeyRef("db", "password")},
+				{Name: "API_TOKEN", Value: "manifest-token"},
+				{Name: "SAME", Value: "x"},
+			},
+			EnvFrom: []corev1.EnvFromSource{{ConfigMapRef: &corev1.ConfigMapEnvSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: "app-config"},
+			}}},
+		}},
+	}
+	live := &corev1.PodSpec{
+		Containers: []corev1.Container{{
+			Name: "app",
+			Env: []corev1.EnvVar{
+				{Name: "LOG_LEVEL", Value: "debug"},
+				{Name: "DB_PASSWORD", ValueFrom: secretKeyRef("db-v2", "password")},
+				{Name: "API_TOKEN", Value: "live-token"},
+				{Name: "SAME", Value: "x"},
+				{Name: "DEBUG", Value: "1"},
+			},
+		}},
+	}
+
+	drifts := CompareSpecs(manifest, live, Options{Salt: "pepper"})
+	expected := []struct {
+		container, name string
+		change          differ.ChangeType
+	}{
+		{container: "app", name: "env API_TOKEN", change: differ.Changed},
+		{container: "app", name: "env DB_PASSWORD", change: differ.Changed},
+		{container: "app", name: "env DEBUG", change: differ.Added},
+		{container: "app", name: "env LOG_LEVEL", change: differ.Changed},
+		{container: "app", name: "envFrom configMap app-config", change: differ.Removed},
+		{container: "migrate", name: "", change: differ.Removed},
+	}
+	if len(drifts) != len(expected) {
+		t.Fatalf("CompareSpecs() = %+v, want %d drifts", drifts, len(expected))
+	}
+	for i, want := range expected {
+		got := drifts[i]
+		if got.Layer != LayerSpec || got.Container != want.container || got.Name != want.name || got.Change != want.change {
+			t.Errorf("CompareSpecs()[%d] = %+v, want %s %s %s", i, got, want.container, want.name, want.change)
+		}
+	}
+
+	if drifts[1].Manifest != "secretKeyRef db/password" || drifts[1].Live != "secretKeyRef db-v2/password" {
+		t.Errorf("CompareSpecs() ref drift = %+v", drifts[1])
+	}
+	if drifts[3].Manifest != `value "info"` || drifts[3].Live != `value "debug"` {
+		t.Errorf("CompareSpecs() value drift = %+v", drifts[3])
+	}
+	if token := drifts[0]; strings.Contains(token.Manifest+token.Live, "-token") || !strings.Contains(token.Live, "sha256:") {
+		t.Errorf("CompareSpecs() printed an inline secret: %+v", token)
+	}
+}
+
+func TestCompareValues(t *testing.T) {
+	origin := extractor.Origin{Container: "app"}
+	manifest := []extractor.EnvVar{
+		{Name: "MODE", Value: "fast", Source: extractor.SourceConfigMap, ConfigRef: &extractor.ConfigMapKeyRef{Name: "app-config", Key: "MODE"}, Origin: origin},
+		{Name: "DB_PASSWORD", Value: "old-pw", Source: extractor.SourceSecret, SecretRef: &extractor.SecretKeyRef{Name: "db", Key: "password"}, IsSecret: true, Origin: origin},
+		{Name: "LOG_LEVEL", Value: "info", Source: extractor.SourceDirect, Origin: origin},
+		{Name: "SAME", Value: "x", Source: extractor.SourceDirect, Origin: origin},
+	}
+	live := []extractor.EnvVar{
+		{Name: "MODE", Value: "slow", Source: extractor.SourceConfigMap, ConfigRef: &extractor.ConfigMapKeyRef{Name: "app-config", Key: "MODE"}, Origin: origin},
+		{Name: "DB_PASSWORD", Value: "new-pw", Source: extractor.SourceSecret, SecretRef: &extractor.SecretKeyRef{Name: "db", Key: "password"}, IsSecret: true, Origin: origin},
+		{Name: "LOG_LEVEL", Value: "debug", Source: extractor.SourceDirect, Origin: origin},
+		{Name: "SAME", Value: "x", Source: extractor.SourceDirect, Origin: origin},
+		{Name: "EXTRA", Value: "1", Source: extractor.SourceConfigMap, ConfigRef: &extractor.ConfigMapKeyRef{Name: "app-config", Key: "EXTRA"}, EnvFrom: true, Origin: origin},
+	}
+	specDrifts := []Drift{{Layer: LayerSpec, Change: differ.Changed, Container: "app", Name: "env LOG_LEVEL"}}
+
+	drifts := CompareValues(manifest, live, specDrifts, Options{Salt: "pepper"})
+	if len(drifts) != 3 {
+		t.Fatalf("CompareValues() = %+v, want 3 drifts", drifts)
+	}
+	password, extra, mode := drifts[0], drifts[1], drifts[2]
+	if password.Name != "DB_PASSWORD" || password.Detail != "secret db key password" || strings.Contains(password.Manifest+password.Live, "-pw") {
+		t.Errorf("CompareValues() secret drift = %+v", password)
+	}
+	if extra.Name != "EXTRA" || extra.Change != differ.Added || extra.Detail != "configMap app-config key EXTRA (envFrom)" {
+		t.Errorf("CompareValues() envFrom drift = %+v", extra)
+	}
+	if mode.Layer != LayerValue || mode.Manifest != "fast" || mode.Live != "slow" || mode.Detail != "configMap app-config key MODE" {
+		t.Errorf("CompareValues() configMap drift = %+v", mode)
+	}
+
+	// Keys of an envFrom source that drifted in the spec are not reported again
+	envFrom := func(configMap, key, value string) extractor.EnvVar {
+		return extractor.EnvVar{Name: key, Value: value, Source: extractor.SourceConfigMap, ConfigRef: &extractor.ConfigMapKeyRef{Name: configMap, Key: key}, EnvFrom: true, Origin: origin}
+	}
+	sourceDrifts := []Drift{
+		{Layer: LayerSpec, Change: differ.Removed, Container: "app", Name: "envFrom configMap old-config"},
+		{Layer: LayerSpec, Change: differ.Added, Container: "app", Name: "envFrom configMap new-config"},
+	}
+	drifts = CompareValues(
+		[]extractor.EnvVar{envFrom("old-config", "A", "1"), envFrom("old-config", "B", "2"), envFrom("shared", "C", "3")},
+		[]extractor.EnvVar{envFrom("new-config", "B", "20"), envFrom("new-config", "D", "4"), envFrom("shared", "C", "30")},
+		sourceDrifts, Options{},
+	)
+	if len(drifts) != 1 || drifts[0].Name != "C" || drifts[0].Detail != "configMap shared key C (envFrom)" {
+		t.Errorf("CompareValues() = %+v, want only C from the unchanged envFrom source", drifts)
+	}
+
+	// A container missing on one side is a spec drift only
+	missing := []Drift{{Layer: LayerSpec, Change: differ.Removed, Container: "app"}}
+	if drifts := CompareValues(manifest, nil, missing, Options{}); len(drifts) != 0 {
+		t.Errorf("CompareValues() = %+v, want nothing for a missing container", drifts)
+	}
+}
+
+func TestCompareRollout(t *testing.T) {
+	started := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "api-1"},
+		Status:     corev1.PodStatus{StartTime: &metav1.Time{Time: started}},
+	}
+
+	configMap := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+		CreationTimestamp: metav1.Time{Time: started.Add(-time.Hour)},
+		ManagedFields: []metav1.ManagedFieldsEntry{
+			{Manager: "kubectl-client-side-apply", Time: &metav1.Time{Time: started.Add(-time.Hour)}},
+			{Manager: "kubectl-edit", Time: &metav1.Time{Time: started.Add(time.Hour)}},
+		},
+	}}
+	if modified := LastModified(configMap); !modified.Equal(started.Add(time.Hour)) {
+		t.Errorf("LastModified() = %v", modified)
+	}
+
+	objects := []Object{
+		{Kind: "configMap", Name: "app-config", Modified: LastModified(configMap)},
+		{Kind: "secret", Name: "db", Modified: started.Add(-time.Minute)},
+	}
+	drifts := CompareRollout(pod, objects)
+	if len(drifts) != 1 || drifts[0].Layer != LayerRollout || drifts[0].Name != "configMap app-config" {
+		t.Fatalf("CompareRollout() = %+v", drifts)
+	}
+	if !strings.Contains(drifts[0].Detail, "after pod api-1 started at 2026-10-01T12:00:00Z") {
+		t.Errorf("CompareRollout() detail = %q", drifts[0].Detail)
+	}
+
+	if drifts := CompareRollout(&corev1.Pod{}, objects); drifts != nil {
+		t.Errorf("CompareRollout() = %+v, want nothing for a pod that has not started", drifts)
+	}
+}
+
+func TestReferencedObjects(t *testing.T) {
+	spec := &corev1.PodSpec{
+		InitContainers: []corev1.Container{{Name: "init", Env: []corev1.EnvVar{{Name: "A", ValueFrom: secretKeyRef("db", "a")}}}},
+		Containers: []corev1.Container{{
+			Name: "app",
+			Env:  []corev1.EnvVar{{Name: "B", ValueFrom: secretKeyRef("db", "b")}, {Name: "C", Value: "c"}},
+			EnvFrom: []corev1.EnvFromSource{{ConfigMapRef: &corev1.ConfigMapEnvSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: "app-config"},
+			}}},
+		}},
+	}
+	objects := ReferencedObjects(spec)
+	if len(objects) != 2 || objects[0] != (Object{Kind: "secret", Name: "db"}) || objects[1] != (Object{Kind: "configMap", Name: "app-config"}) {
+		t.Errorf("ReferencedObjects() = %+v", objects)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	drifts := []Drift{
+		{Layer: LayerSpec, Change: differ.Changed, Container: "app", Name: "env LOG_LEVEL", Manifest: `value "info"`, Live: `value "debug"`},
+		{Layer: LayerValue, Change: differ.Added, Container: "app", Name: "EXTRA", Live: "1", Detail: "configMap app-config key EXTRA (envFrom)"},
+		{Layer: LayerRollout, Change: differ.Changed, Name: "configMap app-config", Detail: "modified"},
+	}
+
+	table, err := Format(drifts, "table", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `LAYER    CHANGE   CONTAINER  NAME                  MANIFEST      LIVE           DETAIL
+spec     changed  app        env LOG_LEVEL         value "info"  value "debug"  -
+value    added    app        EXTRA                 -             1              configMap app-config key EXTRA (envFrom)
+rollout  changed  -          configMap app-config  -             -              modified`
+	if table != expected {
+		t.Errorf("FormatTable() =\n%s\nwant\n%s", table, expected)
+	}
+
+	output, err := Format(drifts, "json", "deploy.yaml", "prod/Deployment/api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report Report
+	if err := json.Unmarshal([]byte(output), &report); err != nil {
+		t.Fatalf("FormatJSON() produced invalid json: %v", err)
+	}
+	if report.SchemaVersion != "keex/v1" || report.Live != "prod/Deployment/api" || len(report.Drifts) != 3 || report.Drifts[1].Layer != LayerValue {
+		t.Errorf("FormatJSON() = %s", output)
+	}
+
+	if _, err := Format(drifts, "unified", "", ""); err == nil {
+		t.Error("Format() expected error for unknown output")
+	}
+	if empty, _ := Format(nil, "table", "", ""); empty != "" {
+		t.Errorf("FormatTable() without drift = %q, want empty", empty)
+	}
+}
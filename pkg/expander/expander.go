@@ -0,0 +1,119 @@
+package expander
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/whywaita/keex/pkg/extractor"
+)
+
+// ExpandAll expands $(VAR_NAME) references in env values the way the kubelet
+// does. References are resolved within the same container: values imported
+// with envFrom are visible to every env entry, and each env entry sees the
+// entries defined before it. Only literal values are
+// expanded, values from refs are used as they are. "$$" escapes a "$", and
+// references to undefined variables are left untouched. Values that reference
+// a secret are marked as secret.
+func ExpandAll(envVars []extractor.EnvVar) []extractor.EnvVar {
+	// Variables defined so far, per container
+	defined := make(map[extractor.Origin]map[string]extractor.EnvVar)
+	define := func(env extractor.EnvVar) {
+		if defined[env.Origin] == nil {
+			defined[env.Origin] = make(map[string]extractor.EnvVar)
+		}
+		defined[env.Origin][env.Name] = env
+	}
+
+	for _, env := range envVars {
+		if env.EnvFrom && !isComment(env) {
+			define(env)
+		}
+	}
+
+	expanded := make([]extractor.EnvVar, 0, len(envVars))
+	for _, env := range envVars {
+		if isComment(env) || env.EnvFrom {
+			expanded = append(expanded, env)
+			continue
+		}
+
+		if env.Source == extractor.SourceDirect && env.Value != "" {
+			var undefined []string
+			env.Value = Expand(env.Value, func(name string) (string, bool) {
+				ref, ok := defined[env.Origin][name]
+				if !ok {
+					undefined = append(undefined, name)
+				}
+				// A value that embeds a secret is a secret itself
+				if ref.IsSecret {
+					env.IsSecret = true
+				}
+				return ref.Value, ok
+			})
+			for _, name := range undefined {
+				fmt.Fprintf(os.Stderr, "Warning: $(%s) referenced by %s is not defined\n", name, env.Name)
+			}
+		}
+
+		define(env)
+		expanded = append(expanded, env)
+	}
+
+	return expanded
+}
+
+// Expand replaces $(VAR_NAME) in input using mapping. References that mapping
+// does not know are kept as written.
+func Expand(input string, mapping func(string) (string, bool)) string {
+	var buf strings.Builder
+	checkpoint := 0
+
+	for cursor := 0; cursor < len(input); cursor++ {
+		if input[cursor] != '$' || cursor+1 >= len(input) {
+			continue
+		}
+
+		buf.WriteString(input[checkpoint:cursor])
+		read, isVar, advance := readVariableName(input[cursor+1:])
+		if isVar {
+			if value, ok := mapping(read); ok {
+				buf.WriteString(value)
+			} else {
+				buf.WriteString("$(" + read + ")")
+			}
+		} else {
+			buf.WriteString(read)
+		}
+
+		cursor += advance
+		checkpoint = cursor + 1
+	}
+
+	return buf.String() + input[checkpoint:]
+}
+
+// readVariableName reads the text following a "$". It returns the variable
+// name or the literal text to emit, whether it was a variable, and how many
+// bytes were consumed.
+func readVariableName(input string) (string, bool, int) {
+	switch input[0] {
+	case '$':
+		// Escaped "$$"
+		return "$", false, 1
+	case '(':
+		for i := 1; i < len(input); i++ {
+			if input[i] == ')' {
+				return input[1:i], true, i + 1
+			}
+		}
+		// Unterminated reference
+		return "$(", false, 1
+	default:
+		return "$" + input[0:1], false, 1
+	}
+}
+
+func isComment(env extractor.EnvVar) bool {
+	return strings.HasPrefix(env.Name, "#")
+}
@@ -1,12 +1,19 @@
 package extractor
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"strings"
 
 	appsv1 "k8s.io/api/apps/v1"
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
 	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
@@ -28,9 +35,40 @@ func New() *Extractor {
 }
 
 func (e *Extractor) Extract(reader io.Reader, opts Options) ([]EnvVar, error) {
+	result, err := e.ExtractAll(reader, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(result.EnvVars) == 0 {
+		return nil, ErrNoEnvVars
+	}
+
+	return result.EnvVars, nil
+}
+
+// ErrNoEnvVars is returned by Extract when the selected workloads define no
+// environment variables
+var ErrNoEnvVars = errors.New("no environment variables found")
+
+// ExtractAll extracts environment variables like Extract and also collects
+// the workloads and the Secret and ConfigMap objects contained in the
+// manifest stream, so that references can be resolved without a cluster.
+// Workloads without environment variables are returned all the same, they
+// may still mount volumes or differ from a live workload.
+func (e *Extractor) ExtractAll(reader io.Reader, opts Options) (*Result, error) {
 	yamlReader := utilyaml.NewYAMLOrJSONDecoder(reader, 4096)
 
-	var envVars []EnvVar
+	selector := labels.Everything()
+	if opts.Selector != "" {
+		var err error
+		selector, err = labels.Parse(opts.Selector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid selector: %w", err)
+		}
+	}
+
+	result := &Result{}
 
 	for {
 		var rawObj runtime.RawExtension
@@ -45,49 +83,164 @@ func (e *Extractor) Extract(reader io.Reader, opts Options) ([]EnvVar, error) {
 			continue
 		}
 
-		obj, gvk, err := e.decoder.Decode(rawObj.Raw, nil, nil)
-		if err != nil {
-			return nil, fmt.Errorf("failed to decode object: %w", err)
+		if err := e.extractDocument(rawObj.Raw, opts, selector, result); err != nil {
+			return nil, err
 		}
+	}
 
-		var extractedVars []EnvVar
-
-		switch gvk.Kind {
-		case "Deployment":
-			deployment := obj.(*appsv1.Deployment)
-			extractedVars = ExtractFromPodSpec(&deployment.Spec.Template.Spec, opts.Container)
-		case "StatefulSet":
-			statefulSet := obj.(*appsv1.StatefulSet)
-			extractedVars = ExtractFromPodSpec(&statefulSet.Spec.Template.Spec, opts.Container)
-		case "DaemonSet":
-			daemonSet := obj.(*appsv1.DaemonSet)
-			extractedVars = ExtractFromPodSpec(&daemonSet.Spec.Template.Spec, opts.Container)
-		case "Job":
-			job := obj.(*batchv1.Job)
-			extractedVars = ExtractFromPodSpec(&job.Spec.Template.Spec, opts.Container)
-		case "CronJob":
-			cronJob := obj.(*batchv1.CronJob)
-			extractedVars = ExtractFromPodSpec(&cronJob.Spec.JobTemplate.Spec.Template.Spec, opts.Container)
-		case "Pod":
-			pod := obj.(*corev1.Pod)
-			extractedVars = ExtractFromPodSpec(&pod.Spec, opts.Container)
-		default:
-			return nil, fmt.Errorf("unsupported resource type: %s", gvk.Kind)
-		}
+	return result, nil
+}
 
-		// Mark secrets as IsSecret for redaction support in keex
-		for i := range extractedVars {
-			if extractedVars[i].Source == SourceSecret {
-				extractedVars[i].IsSecret = true
+// extractDocument extracts a single JSON document into result. List documents
+// are unwrapped recursively. Documents that are not workloads or do not match
+// the filters in opts are skipped.
+func (e *Extractor) extractDocument(raw []byte, opts Options, selector labels.Selector, result *Result) error {
+	items, isList, err := splitList(raw)
+	if err != nil {
+		return err
+	}
+	if isList {
+		for _, item := range items {
+			if err := e.extractDocument(item, opts, selector, result); err != nil {
+				return err
 			}
 		}
+		return nil
+	}
 
-		envVars = append(envVars, extractedVars...)
+	obj, gvk, err := e.decoder.Decode(raw, nil, nil)
+	if runtime.IsNotRegisteredError(err) {
+		// Unknown kinds, typically CRDs, are searched for a pod template
+		obj = &unstructured.Unstructured{}
+		if err := obj.(*unstructured.Unstructured).UnmarshalJSON(raw); err != nil {
+			return fmt.Errorf("failed to decode object: %w", err)
+		}
+	} else if err != nil {
+		return fmt.Errorf("failed to decode object: %w", err)
 	}
 
-	if len(envVars) == 0 {
-		return nil, fmt.Errorf("no environment variables found")
+	// Secrets and ConfigMaps are collected regardless of the filters
+	switch o := obj.(type) {
+	case *corev1.Secret:
+		result.Secrets = append(result.Secrets, o)
+		return nil
+	case *corev1.ConfigMap:
+		result.ConfigMaps = append(result.ConfigMaps, o)
+		return nil
 	}
 
-	return envVars, nil
+	kind := obj.GetObjectKind().GroupVersionKind().Kind
+	if gvk != nil {
+		kind = gvk.Kind
+	}
+	if !matchesFilters(obj, kind, opts, selector) {
+		return nil
+	}
+
+	var pod *corev1.Pod
+
+	switch o := obj.(type) {
+	case *appsv1.Deployment:
+		pod = PodFromTemplate(o.ObjectMeta, &o.Spec.Template)
+	case *appsv1.StatefulSet:
+		pod = PodFromTemplate(o.ObjectMeta, &o.Spec.Template)
+	case *appsv1.DaemonSet:
+		pod = PodFromTemplate(o.ObjectMeta, &o.Spec.Template)
+	case *appsv1.ReplicaSet:
+		pod = PodFromTemplate(o.ObjectMeta, &o.Spec.Template)
+	case *batchv1.Job:
+		pod = PodFromTemplate(o.ObjectMeta, &o.Spec.Template)
+	case *batchv1.CronJob:
+		pod = PodFromTemplate(o.ObjectMeta, &o.Spec.JobTemplate.Spec.Template)
+	case *corev1.ReplicationController:
+		if o.Spec.Template == nil {
+			return fmt.Errorf("replicationcontroller %s has no pod template", o.Name)
+		}
+		pod = PodFromTemplate(o.ObjectMeta, o.Spec.Template)
+	case *corev1.PodTemplate:
+		pod = PodFromTemplate(o.ObjectMeta, &o.Template)
+	case *corev1.Pod:
+		pod = o
+	case *unstructured.Unstructured:
+		template, err := findPodTemplate(o, opts.PodSpecPaths)
+		if err != nil {
+			return err
+		}
+		if template == nil {
+			// Not a workload
+			return nil
+		}
+		pod = PodFromTemplate(metav1.ObjectMeta{Name: o.GetName(), Namespace: o.GetNamespace()}, template)
+	default:
+		// Services, Ingresses and other non-workload documents
+		return nil
+	}
+
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return fmt.Errorf("failed to read metadata of %s: %w", kind, err)
+	}
+
+	// Resolve fieldRef/resourceFieldRef from the manifest itself
+	extractedVars := ResolveFieldRefs(ExtractFromPodSpec(&pod.Spec, opts.Container), pod)
+	extractedVars = WithResource(extractedVars, kind, accessor.GetNamespace(), accessor.GetName())
+
+	result.EnvVars = append(result.EnvVars, extractedVars...)
+	result.Workloads = append(result.Workloads, Workload{
+		Kind:      kind,
+		Namespace: accessor.GetNamespace(),
+		Name:      accessor.GetName(),
+		Pod:       pod,
+		Object:    obj,
+	})
+	return nil
+}
+
+// splitList returns the items of a List or *List document such as the output
+// of kubectl get -o yaml. Items of typed lists may omit apiVersion and kind,
+// which are then derived from the list.
+func splitList(raw []byte) ([][]byte, bool, error) {
+	var list struct {
+		APIVersion string                   `json:"apiVersion"`
+		Kind       string                   `json:"kind"`
+		Items      []map[string]interface{} `json:"items"`
+	}
+	if err := json.Unmarshal(raw, &list); err != nil {
+		// Not a list, let the decoder report the error
+		return nil, false, nil
+	}
+	if !strings.HasSuffix(list.Kind, "List") || list.Items == nil {
+		return nil, false, nil
+	}
+
+	items := make([][]byte, 0, len(list.Items))
+	for _, item := range list.Items {
+		if _, ok := item["kind"]; !ok && list.Kind != "List" {
+			item["kind"] = strings.TrimSuffix(list.Kind, "List")
+			item["apiVersion"] = list.APIVersion
+		}
+		data, err := json.Marshal(item)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to encode list item: %w", err)
+		}
+		items = append(items, data)
+	}
+	return items, true, nil
+}
+
+// matchesFilters reports whether obj matches the kind, name and label
+// selector filters of opts
+func matchesFilters(obj runtime.Object, kind string, opts Options, selector labels.Selector) bool {
+	if opts.Kind != "" && !strings.EqualFold(opts.Kind, kind) {
+		return false
+	}
+
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return opts.Name == "" && selector.Empty()
+	}
+	if opts.Name != "" && opts.Name != accessor.GetName() {
+		return false
+	}
+	return selector.Matches(labels.Set(accessor.GetLabels()))
 }
@@ -1,6 +1,7 @@
 package extractor
 
 import (
+	"errors"
 	"strings"
 	"testing"
 )
@@ -124,3 +125,569 @@ metadata:
 		})
 	}
 }
+
+func TestExtractor_ExtractAll_CollectsObjects(t *testing.T) {
+	manifest := `apiVersion: v1
+kind: Secret
+metadata:
+  name: db-secret
+stringData:
+  password: s3cr3t
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app-config
+data:
+  config-path: /etc/app
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: test-app
+spec:
+  template:
+    spec:
+      containers:
+      - name: app
+        env:
+        - name: DB_PASS
+          valueFrom:
+            secretKeyRef:
+              name: db-secret
+              key: password`
+
+	result, err := New().ExtractAll(strings.NewReader(manifest), Options{})
+	if err != nil {
+		t.Fatalf("ExtractAll() error = %v", err)
+	}
+
+	if len(result.EnvVars) != 1 {
+		t.Errorf("ExtractAll() got %d env vars, want 1", len(result.EnvVars))
+	}
+	if len(result.Secrets) != 1 || result.Secrets[0].Name != "db-secret" {
+		t.Errorf("ExtractAll() got secrets %v, want [db-secret]", result.Secrets)
+	}
+	if len(result.ConfigMaps) != 1 || result.ConfigMaps[0].Name != "app-config" {
+		t.Errorf("ExtractAll() got configmaps %v, want [app-config]", result.ConfigMaps)
+	}
+}
+
+func TestExtractor_ExtractAll_WithoutEnvVars(t *testing.T) {
+	manifest := `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: test-app
+spec:
+  template:
+    spec:
+      containers:
+      - name: app
+        volumeMounts:
+        - name: config
+          mountPath: /etc/app
+      volumes:
+      - name: config
+        configMap:
+          name: app-config`
+
+	result, err := New().ExtractAll(strings.NewReader(manifest), Options{})
+	if err != nil {
+		t.Fatalf("ExtractAll() error = %v", err)
+	}
+	if len(result.Workloads) != 1 || len(result.EnvVars) != 0 {
+		t.Errorf("ExtractAll() got %d workloads and %d env vars, want the workload without env vars", len(result.Workloads), len(result.EnvVars))
+	}
+
+	if _, err := New().Extract(strings.NewReader(manifest), Options{}); !errors.Is(err, ErrNoEnvVars) {
+		t.Errorf("Extract() error = %v, want ErrNoEnvVars", err)
+	}
+}
+
+func TestExtractor_Extract_FieldRefs(t *testing.T) {
+	manifest := `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: test-app
+  namespace: backend
+spec:
+  template:
+    metadata:
+      labels:
+        app: test-app
+    spec:
+      serviceAccountName: test-sa
+      containers:
+      - name: app
+        resources:
+          requests:
+            cpu: 250m
+          limits:
+            cpu: "1"
+            memory: 512Mi
+        env:
+        - name: POD_NAME
+          valueFrom:
+            fieldRef:
+              fieldPath: metadata.name
+        - name: POD_NAMESPACE
+          valueFrom:
+            fieldRef:
+              fieldPath: metadata.namespace
+        - name: APP_LABEL
+          valueFrom:
+            fieldRef:
+              fieldPath: metadata.labels['app']
+        - name: SERVICE_ACCOUNT
+          valueFrom:
+            fieldRef:
+              fieldPath: spec.serviceAccountName
+        - name: POD_IP
+          valueFrom:
+            fieldRef:
+              fieldPath: status.podIP
+        - name: MEMORY_LIMIT_MI
+          valueFrom:
+            resourceFieldRef:
+              resource: limits.memory
+              divisor: 1Mi
+        - name: CPU_REQUEST_MILLI
+          valueFrom:
+            resourceFieldRef:
+              resource: requests.cpu
+              divisor: 1m
+        - name: CPU_LIMIT
+          valueFrom:
+            resourceFieldRef:
+              containerName: app
+              resource: limits.cpu`
+
+	envVars, err := New().Extract(strings.NewReader(manifest), Options{})
+	if err != nil {
+		t.Fatalf("Extract() error = %v", err)
+	}
+
+	expected := map[string]string{
+		"POD_NAME":          "test-app",
+		"POD_NAMESPACE":     "backend",
+		"APP_LABEL":         "test-app",
+		"SERVICE_ACCOUNT":   "test-sa",
+		"POD_IP":            "<fieldRef:status.podIP>",
+		"MEMORY_LIMIT_MI":   "512",
+		"CPU_REQUEST_MILLI": "250",
+		"CPU_LIMIT":         "1",
+	}
+	for _, env := range envVars {
+		if want, ok := expected[env.Name]; ok && env.Value != want {
+			t.Errorf("Extract() %s = %q, want %q", env.Name, env.Value, want)
+		}
+	}
+
+	if envVars[0].Source != SourceFieldRef {
+		t.Errorf("Extract() POD_NAME source = %v, want SourceFieldRef", envVars[0].Source)
+	}
+	if envVars[5].Source != SourceResourceFieldRef {
+		t.Errorf("Extract() MEMORY_LIMIT_MI source = %v, want SourceResourceFieldRef", envVars[5].Source)
+	}
+}
+
+func TestExtractor_Extract_CustomResources(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+		opts     Options
+		wantEnv  string
+		wantErr  bool
+	}{
+		{
+			name: "argo rollout",
+			manifest: `apiVersion: argoproj.io/v1alpha1
+kind: Rollout
+metadata:
+  name: rollout
+spec:
+  strategy:
+    canary: {}
+  template:
+    spec:
+      containers:
+      - name: app
+        env:
+        - name: FOO
+          value: rollout`,
+			wantEnv: "rollout",
+		},
+		{
+			name: "knative service",
+			manifest: `apiVersion: serving.knative.dev/v1
+kind: Service
+metadata:
+  name: ksvc
+spec:
+  template:
+    spec:
+      containerConcurrency: 10
+      containers:
+      - image: app
+        env:
+        - name: FOO
+          value: knative`,
+			wantEnv: "knative",
+		},
+		{
+			name: "keda scaled job",
+			manifest: `apiVersion: keda.sh/v1alpha1
+kind: ScaledJob
+metadata:
+  name: scaled
+spec:
+  jobTargetRef:
+    template:
+      spec:
+        containers:
+        - name: worker
+          env:
+          - name: FOO
+            value: keda`,
+			wantEnv: "keda",
+		},
+		{
+			name: "tekton task",
+			manifest: `apiVersion: tekton.dev/v1
+kind: Task
+metadata:
+  name: task
+spec:
+  steps:
+  - name: build
+    image: golang
+    script: go build ./...
+    env:
+    - name: FOO
+      value: tekton`,
+			wantEnv: "tekton",
+		},
+		{
+			name: "custom pod spec path",
+			manifest: `apiVersion: example.com/v1
+kind: Worker
+metadata:
+  name: worker
+spec:
+  runtime:
+    pod:
+      containers:
+      - name: worker
+        env:
+        - name: FOO
+          value: custom`,
+			opts: Options{PodSpecPaths: []PodSpecPath{
+				{APIVersion: "example.com/v1", Kind: "Worker", Path: ".spec.runtime.pod"},
+			}},
+			wantEnv: "custom",
+		},
+		{
+			name: "unknown kind without pod template",
+			manifest: `apiVersion: example.com/v1
+kind: Widget
+metadata:
+  name: widget
+spec:
+  size: 3`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			envVars, err := New().Extract(strings.NewReader(tt.manifest), tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Extract() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(envVars) != 1 || envVars[0].Value != tt.wantEnv {
+				t.Errorf("Extract() = %v, want FOO=%s", envVars, tt.wantEnv)
+			}
+		})
+	}
+}
+
+func TestParsePodSpecPath(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    PodSpecPath
+		wantErr bool
+	}{
+		{input: "Worker=.spec.pod", want: PodSpecPath{Kind: "Worker", Path: ".spec.pod"}},
+		{input: "argoproj.io/v1alpha1/Rollout=.spec.template", want: PodSpecPath{APIVersion: "argoproj.io/v1alpha1", Kind: "Rollout", Path: ".spec.template"}},
+		{input: "v1/Worker={.spec.pod}", want: PodSpecPath{APIVersion: "v1", Kind: "Worker", Path: "{.spec.pod}"}},
+		{input: "Worker", wantErr: true},
+		{input: "=.spec", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParsePodSpecPath(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParsePodSpecPath() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParsePodSpecPath() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractor_Extract_ListsAndControllers(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+		wantEnv  []string
+	}{
+		{
+			name: "replicaset",
+			manifest: `apiVersion: apps/v1
+kind: ReplicaSet
+metadata:
+  name: rs
+spec:
+  template:
+    spec:
+      containers:
+      - name: app
+        env:
+        - name: FOO
+          value: rs`,
+			wantEnv: []string{"rs"},
+		},
+		{
+			name: "replicationcontroller",
+			manifest: `apiVersion: v1
+kind: ReplicationController
+metadata:
+  name: rc
+spec:
+  template:
+    spec:
+      containers:
+      - name: app
+        env:
+        - name: FOO
+          value: rc`,
+			wantEnv: []string{"rc"},
+		},
+		{
+			name: "podtemplate",
+			manifest: `apiVersion: v1
+kind: PodTemplate
+metadata:
+  name: pt
+template:
+  spec:
+    containers:
+    - name: app
+      env:
+      - name: FOO
+        value: pt`,
+			wantEnv: []string{"pt"},
+		},
+		{
+			name: "kubectl get list",
+			manifest: `apiVersion: v1
+kind: List
+items:
+- apiVersion: apps/v1
+  kind: Deployment
+  metadata:
+    name: first
+  spec:
+    template:
+      spec:
+        containers:
+        - name: app
+          env:
+          - name: FOO
+            value: first
+- apiVersion: v1
+  kind: List
+  items:
+  - apiVersion: v1
+    kind: Pod
+    metadata:
+      name: nested
+    spec:
+      containers:
+      - name: app
+        env:
+        - name: FOO
+          value: nested`,
+			wantEnv: []string{"first", "nested"},
+		},
+		{
+			name: "typed list without item kinds",
+			manifest: `apiVersion: apps/v1
+kind: DeploymentList
+items:
+- metadata:
+    name: typed
+  spec:
+    template:
+      spec:
+        containers:
+        - name: app
+          env:
+          - name: FOO
+            value: typed`,
+			wantEnv: []string{"typed"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			envVars, err := New().Extract(strings.NewReader(tt.manifest), Options{})
+			if err != nil {
+				t.Fatalf("Extract() error = %v", err)
+			}
+			if len(envVars) != len(tt.wantEnv) {
+				t.Fatalf("Extract() got %d env vars, want %d", len(envVars), len(tt.wantEnv))
+			}
+			for i, want := range tt.wantEnv {
+				if envVars[i].Value != want {
+					t.Errorf("Extract()[%d] = %q, want %q", i, envVars[i].Value, want)
+				}
+			}
+		})
+	}
+}
+
+func TestExtractor_Extract_Filters(t *testing.T) {
+	manifest := `apiVersion: v1
+kind: Service
+metadata:
+  name: api
+spec:
+  ports:
+  - port: 80
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: api
+  labels:
+    tier: backend
+spec:
+  template:
+    spec:
+      containers:
+      - name: app
+        env:
+        - name: FOO
+          value: deploy-api
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  labels:
+    tier: frontend
+spec:
+  template:
+    spec:
+      containers:
+      - name: app
+        env:
+        - name: FOO
+          value: deploy-web
+---
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: api
+  labels:
+    tier: backend
+spec:
+  template:
+    spec:
+      containers:
+      - name: migrate
+        env:
+        - name: FOO
+          value: job-api`
+
+	tests := []struct {
+		name    string
+		opts    Options
+		want    []string
+		wantErr bool
+	}{
+		{name: "no filters skips non-workloads", opts: Options{}, want: []string{"deploy-api", "deploy-web", "job-api"}},
+		{name: "kind and name", opts: Options{Kind: "deployment", Name: "api"}, want: []string{"deploy-api"}},
+		{name: "name only", opts: Options{Name: "api"}, want: []string{"deploy-api", "job-api"}},
+		{name: "selector", opts: Options{Selector: "tier=frontend"}, want: []string{"deploy-web"}},
+		{name: "selector and kind", opts: Options{Kind: "Job", Selector: "tier in (backend)"}, want: []string{"job-api"}},
+		{name: "no match", opts: Options{Kind: "StatefulSet"}, wantErr: true},
+		{name: "invalid selector", opts: Options{Selector: "tier in"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			envVars, err := New().Extract(strings.NewReader(manifest), tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Extract() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(envVars) != len(tt.want) {
+				t.Fatalf("Extract() got %d env vars, want %d", len(envVars), len(tt.want))
+			}
+			for i, want := range tt.want {
+				if envVars[i].Value != want {
+					t.Errorf("Extract()[%d] = %q, want %q", i, envVars[i].Value, want)
+				}
+			}
+		})
+	}
+}
+
+func TestExtractor_Extract_Origin(t *testing.T) {
+	manifest := `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: api
+  namespace: backend
+spec:
+  template:
+    spec:
+      initContainers:
+      - name: migrate
+        env:
+        - name: FOO
+          value: init
+      containers:
+      - name: app
+        env:
+        - name: FOO
+          value: app
+        envFrom:
+        - configMapRef:
+            name: app-config`
+
+	envVars, err := New().Extract(strings.NewReader(manifest), Options{})
+	if err != nil {
+		t.Fatalf("Extract() error = %v", err)
+	}
+
+	expected := []Origin{
+		{Kind: "Deployment", Namespace: "backend", Name: "api", Container: "migrate", InitContainer: true},
+		{Kind: "Deployment", Namespace: "backend", Name: "api", Container: "app"},
+		{Kind: "Deployment", Namespace: "backend", Name: "api", Container: "app"},
+	}
+	if len(envVars) != len(expected) {
+		t.Fatalf("Extract() got %d env vars, want %d", len(envVars), len(expected))
+	}
+	for i, want := range expected {
+		if envVars[i].Origin != want {
+			t.Errorf("Extract()[%d].Origin = %+v, want %+v", i, envVars[i].Origin, want)
+		}
+	}
+}
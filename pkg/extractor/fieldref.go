@@ -0,0 +1,183 @@
+package extractor
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func fieldRefPlaceholder(ref *ObjectFieldRef) string {
+	return fmt.Sprintf("<fieldRef:%s>", ref.FieldPath)
+}
+
+func resourceFieldRefPlaceholder(ref *ResourceFieldRef) string {
+	return fmt.Sprintf("<resourceFieldRef:%s:%s>", ref.ContainerName, ref.Resource)
+}
+
+// PodFromTemplate builds a pod as the workload controller would create it from
+// its template, so that fieldRef values can be resolved without a cluster.
+func PodFromTemplate(meta metav1.ObjectMeta, template *corev1.PodTemplateSpec) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: *template.ObjectMeta.DeepCopy(),
+		Spec:       *template.Spec.DeepCopy(),
+	}
+	if pod.Name == "" {
+		pod.Name = meta.Name
+	}
+	if pod.Namespace == "" {
+		pod.Namespace = meta.Namespace
+	}
+	return pod
+}
+
+// ResolveFieldRefs resolves fieldRef and resourceFieldRef env vars from the
+// given pod. Values that are unknown for the pod, such as status.podIP of a
+// pod that has not been scheduled, keep their placeholder.
+func ResolveFieldRefs(envVars []EnvVar, pod *corev1.Pod) []EnvVar {
+	for i := range envVars {
+		switch envVars[i].Source {
+		case SourceFieldRef:
+			if envVars[i].FieldRef == nil {
+				continue
+			}
+			if value, ok := podFieldValue(pod, envVars[i].FieldRef.FieldPath); ok {
+				envVars[i].Value = value
+			}
+		case SourceResourceFieldRef:
+			if envVars[i].ResourceFieldRef == nil {
+				continue
+			}
+			if value, ok := containerResourceValue(pod, envVars[i].ResourceFieldRef); ok {
+				envVars[i].Value = value
+			}
+		}
+	}
+	return envVars
+}
+
+// podFieldValue returns the value of a downward API field path. It reports
+// false when the field is unsupported or not set on the pod.
+func podFieldValue(pod *corev1.Pod, fieldPath string) (string, bool) {
+	// metadata.labels['key'] and metadata.annotations['key'] always resolve,
+	// missing keys become empty strings as in the kubelet
+	if path, key, ok := splitSubscript(fieldPath); ok {
+		switch path {
+		case "metadata.labels":
+			return pod.Labels[key], true
+		case "metadata.annotations":
+			return pod.Annotations[key], true
+		}
+		return "", false
+	}
+
+	var value string
+	switch fieldPath {
+	case "metadata.name":
+		value = pod.Name
+	case "metadata.namespace":
+		value = pod.Namespace
+	case "metadata.uid":
+		value = string(pod.UID)
+	case "spec.nodeName":
+		value = pod.Spec.NodeName
+	case "spec.serviceAccountName":
+		value = pod.Spec.ServiceAccountName
+	case "status.hostIP":
+		value = pod.Status.HostIP
+	case "status.hostIPs":
+		ips := make([]string, 0, len(pod.Status.HostIPs))
+		for _, ip := range pod.Status.HostIPs {
+			ips = append(ips, ip.IP)
+		}
+		value = strings.Join(ips, ",")
+	case "status.podIP":
+		value = pod.Status.PodIP
+	case "status.podIPs":
+		ips := make([]string, 0, len(pod.Status.PodIPs))
+		for _, ip := range pod.Status.PodIPs {
+			ips = append(ips, ip.IP)
+		}
+		value = strings.Join(ips, ",")
+	}
+
+	return value, value != ""
+}
+
+// splitSubscript splits "metadata.labels['key']" into its path and key
+func splitSubscript(fieldPath string) (string, string, bool) {
+	open := strings.Index(fieldPath, "[")
+	if open == -1 || !strings.HasSuffix(fieldPath, "]") {
+		return "", "", false
+	}
+
+	key := fieldPath[open+1 : len(fieldPath)-1]
+	unquoted, err := strconv.Unquote(key)
+	if err != nil {
+		// Single quoted keys are not valid Go strings
+		if len(key) < 2 || key[0] != '\'' || key[len(key)-1] != '\'' {
+			return "", "", false
+		}
+		unquoted = key[1 : len(key)-1]
+	}
+
+	return fieldPath[:open], unquoted, true
+}
+
+// containerResourceValue returns the value of a resourceFieldRef with the
+// divisor applied, rounding up like the kubelet does.
+func containerResourceValue(pod *corev1.Pod, ref *ResourceFieldRef) (string, bool) {
+	var container *corev1.Container
+	for _, c := range append(append([]corev1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...) {
+		if c.Name == ref.ContainerName {
+			container = &c
+			break
+		}
+	}
+	if container == nil {
+		return "", false
+	}
+
+	kind, name, ok := strings.Cut(ref.Resource, ".")
+	if !ok {
+		return "", false
+	}
+
+	var quantity resource.Quantity
+	switch kind {
+	case "limits":
+		// Unset limits default to the node allocatable, which is unknown here
+		q, ok := container.Resources.Limits[corev1.ResourceName(name)]
+		if !ok {
+			return "", false
+		}
+		quantity = q
+	case "requests":
+		// Unset requests default to the limits
+		if q, ok := container.Resources.Requests[corev1.ResourceName(name)]; ok {
+			quantity = q
+		} else if q, ok := container.Resources.Limits[corev1.ResourceName(name)]; ok {
+			quantity = q
+		}
+	default:
+		return "", false
+	}
+
+	divisor := resource.MustParse("1")
+	if ref.Divisor != "" {
+		d, err := resource.ParseQuantity(ref.Divisor)
+		if err != nil || d.IsZero() {
+			return "", false
+		}
+		divisor = d
+	}
+
+	if name == string(corev1.ResourceCPU) {
+		return strconv.FormatInt(int64(math.Ceil(float64(quantity.MilliValue())/float64(divisor.MilliValue()))), 10), true
+	}
+	return strconv.FormatInt(int64(math.Ceil(float64(quantity.Value())/float64(divisor.Value()))), 10), true
+}
@@ -0,0 +1,171 @@
+package extractor
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/util/jsonpath"
+	"sigs.k8s.io/yaml"
+)
+
+// wellKnownPodSpecPaths are the locations of pod templates in common CRDs.
+// Each path may point to a PodTemplateSpec or directly to a PodSpec.
+var wellKnownPodSpecPaths = [][]string{
+	// Argo Rollouts, OpenShift DeploymentConfig, Knative Service and most
+	// Deployment-like CRDs
+	{"spec", "template"},
+	// CronJob-like CRDs
+	{"spec", "jobTemplate", "spec", "template"},
+	// KEDA ScaledJob
+	{"spec", "jobTargetRef", "template"},
+	// Pod-like CRDs
+	{"spec", "podTemplate"},
+	{"spec", "podSpec"},
+}
+
+// PodSpecPath maps a resource kind to the JSONPath of its pod template
+type PodSpecPath struct {
+	APIVersion string `json:"apiVersion,omitempty"`
+	Kind       string `json:"kind"`
+	Path       string `json:"path"`
+}
+
+// PodSpecConfig is the format of a pod spec path config file
+type PodSpecConfig struct {
+	PodSpecPaths []PodSpecPath `json:"podSpecPaths"`
+}
+
+// ParsePodSpecPath parses a mapping of the form KIND=PATH or
+// APIVERSION/KIND=PATH, e.g. "argoproj.io/v1alpha1/Rollout=.spec.template"
+func ParsePodSpecPath(s string) (PodSpecPath, error) {
+	gvk, path, ok := strings.Cut(s, "=")
+	if !ok || gvk == "" || path == "" {
+		return PodSpecPath{}, fmt.Errorf("invalid pod spec path %q, expected [APIVERSION/]KIND=PATH", s)
+	}
+
+	mapping := PodSpecPath{Kind: gvk, Path: path}
+	if i := strings.LastIndex(gvk, "/"); i != -1 {
+		mapping.APIVersion = gvk[:i]
+		mapping.Kind = gvk[i+1:]
+	}
+	return mapping, nil
+}
+
+// LoadPodSpecConfig reads pod spec path mappings from a YAML or JSON config
+func LoadPodSpecConfig(reader io.Reader) ([]PodSpecPath, error) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pod spec config: %w", err)
+	}
+
+	var config PodSpecConfig
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse pod spec config: %w", err)
+	}
+	for _, mapping := range config.PodSpecPaths {
+		if mapping.Kind == "" || mapping.Path == "" {
+			return nil, fmt.Errorf("invalid pod spec config: kind and path are required")
+		}
+	}
+	return config.PodSpecPaths, nil
+}
+
+// findPodTemplate locates the pod template of an arbitrary object. User
+// supplied mappings take precedence over the well-known paths. It returns
+// nil when the object does not contain a pod template.
+func findPodTemplate(obj *unstructured.Unstructured, mappings []PodSpecPath) (*corev1.PodTemplateSpec, error) {
+	for _, mapping := range mappings {
+		if mapping.Kind != obj.GetKind() {
+			continue
+		}
+		if mapping.APIVersion != "" && mapping.APIVersion != obj.GetAPIVersion() {
+			continue
+		}
+
+		value, err := evalJSONPath(obj.Object, mapping.Path)
+		if err != nil {
+			return nil, err
+		}
+		template, err := toPodTemplate(value)
+		if err != nil {
+			return nil, err
+		}
+		if template == nil {
+			return nil, fmt.Errorf("%s does not point to a pod template in %s/%s", mapping.Path, obj.GetKind(), obj.GetName())
+		}
+		return template, nil
+	}
+
+	for _, path := range wellKnownPodSpecPaths {
+		value, found, err := unstructured.NestedFieldNoCopy(obj.Object, path...)
+		if err != nil || !found {
+			continue
+		}
+		template, err := toPodTemplate(value)
+		if err != nil {
+			return nil, err
+		}
+		if template != nil {
+			return template, nil
+		}
+	}
+
+	// Tekton Tasks list their containers as steps
+	if steps, found, err := unstructured.NestedSlice(obj.Object, "spec", "steps"); err == nil && found {
+		return toPodTemplate(map[string]interface{}{"containers": steps})
+	}
+
+	return nil, nil
+}
+
+func evalJSONPath(data map[string]interface{}, path string) (interface{}, error) {
+	if !strings.HasPrefix(path, "{") {
+		path = "{" + path + "}"
+	}
+
+	jp := jsonpath.New("podspec")
+	if err := jp.Parse(path); err != nil {
+		return nil, fmt.Errorf("invalid pod spec path %s: %w", path, err)
+	}
+	results, err := jp.FindResults(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate pod spec path %s: %w", path, err)
+	}
+	if len(results) == 0 || len(results[0]) == 0 {
+		return nil, fmt.Errorf("pod spec path %s matched nothing", path)
+	}
+	return results[0][0].Interface(), nil
+}
+
+// toPodTemplate converts a PodTemplateSpec or PodSpec shaped value. It
+// returns nil when the value has neither shape.
+func toPodTemplate(value interface{}) (*corev1.PodTemplateSpec, error) {
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+
+	if spec, ok := object["spec"].(map[string]interface{}); ok {
+		if _, ok := spec["containers"]; ok {
+			template := &corev1.PodTemplateSpec{}
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(object, template); err != nil {
+				return nil, fmt.Errorf("failed to convert pod template: %w", err)
+			}
+			return template, nil
+		}
+	}
+
+	if _, ok := object["containers"]; ok {
+		template := &corev1.PodTemplateSpec{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(object, &template.Spec); err != nil {
+			return nil, fmt.Errorf("failed to convert pod spec: %w", err)
+		}
+		return template, nil
+	}
+
+	return nil, nil
+}
@@ -10,27 +10,35 @@ import (
 func ExtractFromPodSpec(spec *corev1.PodSpec, containerName string) []EnvVar {
 	var result []EnvVar
 
-	containers := append(spec.InitContainers, spec.Containers...)
-	for _, container := range containers {
+	containers := append(append([]corev1.Container{}, spec.InitContainers...), spec.Containers...)
+	for i, container := range containers {
 		// Skip if container name is specified and doesn't match
 		if containerName != "" && container.Name != containerName {
 			continue
 		}
 
+		origin := Origin{
+			Container:     container.Name,
+			InitContainer: i < len(spec.InitContainers),
+		}
+
 		// Direct env vars
 		for _, env := range container.Env {
 			ev := EnvVar{
-				Name:  env.Name,
-				Value: env.Value,
+				Name:   env.Name,
+				Value:  env.Value,
+				Origin: origin,
 			}
 
 			// Handle valueFrom
 			if env.ValueFrom != nil {
 				if env.ValueFrom.SecretKeyRef != nil {
 					ev.Source = SourceSecret
+					ev.IsSecret = true
 					ev.SecretRef = &SecretKeyRef{
-						Name: env.ValueFrom.SecretKeyRef.Name,
-						Key:  env.ValueFrom.SecretKeyRef.Key,
+						Name:     env.ValueFrom.SecretKeyRef.Name,
+						Key:      env.ValueFrom.SecretKeyRef.Key,
+						Optional: isOptional(env.ValueFrom.SecretKeyRef.Optional),
 					}
 					if ev.Value == "" {
 						ev.Value = fmt.Sprintf("<%s:%s>", ev.SecretRef.Name, ev.SecretRef.Key)
@@ -38,12 +46,33 @@ func ExtractFromPodSpec(spec *corev1.PodSpec, containerName string) []EnvVar {
 				} else if env.ValueFrom.ConfigMapKeyRef != nil {
 					ev.Source = SourceConfigMap
 					ev.ConfigRef = &ConfigMapKeyRef{
-						Name: env.ValueFrom.ConfigMapKeyRef.Name,
-						Key:  env.ValueFrom.ConfigMapKeyRef.Key,
+						Name:     env.ValueFrom.ConfigMapKeyRef.Name,
+						Key:      env.ValueFrom.ConfigMapKeyRef.Key,
+						Optional: isOptional(env.ValueFrom.ConfigMapKeyRef.Optional),
 					}
 					if ev.Value == "" {
 						ev.Value = fmt.Sprintf("<%s:%s>", ev.ConfigRef.Name, ev.ConfigRef.Key)
 					}
+				} else if env.ValueFrom.FieldRef != nil {
+					ev.Source = SourceFieldRef
+					ev.FieldRef = &ObjectFieldRef{
+						FieldPath: env.ValueFrom.FieldRef.FieldPath,
+					}
+					ev.Value = fieldRefPlaceholder(ev.FieldRef)
+				} else if env.ValueFrom.ResourceFieldRef != nil {
+					ev.Source = SourceResourceFieldRef
+					ev.ResourceFieldRef = &ResourceFieldRef{
+						ContainerName: env.ValueFrom.ResourceFieldRef.ContainerName,
+						Resource:      env.ValueFrom.ResourceFieldRef.Resource,
+					}
+					// The referenced container defaults to the current one
+					if ev.ResourceFieldRef.ContainerName == "" {
+						ev.ResourceFieldRef.ContainerName = container.Name
+					}
+					if !env.ValueFrom.ResourceFieldRef.Divisor.IsZero() {
+						ev.ResourceFieldRef.Divisor = env.ValueFrom.ResourceFieldRef.Divisor.String()
+					}
+					ev.Value = resourceFieldRefPlaceholder(ev.ResourceFieldRef)
 				}
 			} else {
 				ev.Source = SourceDirect
@@ -61,14 +90,18 @@ func ExtractFromPodSpec(spec *corev1.PodSpec, containerName string) []EnvVar {
 
 			if envFrom.SecretRef != nil {
 				result = append(result, EnvVar{
-					Name:   fmt.Sprintf("# from secret: %s", envFrom.SecretRef.Name),
-					Value:  "",
-					Source: SourceSecret,
+					Name:     fmt.Sprintf("# from secret: %s", envFrom.SecretRef.Name),
+					Value:    "",
+					Source:   SourceSecret,
+					IsSecret: true,
 					SecretRef: &SecretKeyRef{
-						Name: envFrom.SecretRef.Name,
-						Key:  "*", // All keys
+						Name:     envFrom.SecretRef.Name,
+						Key:      "*", // All keys
+						Optional: isOptional(envFrom.SecretRef.Optional),
 					},
-					Prefix: prefix,
+					Prefix:  prefix,
+					EnvFrom: true,
+					Origin:  origin,
 				})
 			} else if envFrom.ConfigMapRef != nil {
 				result = append(result, EnvVar{
@@ -76,10 +109,13 @@ func ExtractFromPodSpec(spec *corev1.PodSpec, containerName string) []EnvVar {
 					Value:  "",
 					Source: SourceConfigMap,
 					ConfigRef: &ConfigMapKeyRef{
-						Name: envFrom.ConfigMapRef.Name,
-						Key:  "*", // All keys
+						Name:     envFrom.ConfigMapRef.Name,
+						Key:      "*", // All keys
+						Optional: isOptional(envFrom.ConfigMapRef.Optional),
 					},
-					Prefix: prefix,
+					Prefix:  prefix,
+					EnvFrom: true,
+					Origin:  origin,
 				})
 			}
 		}
@@ -92,3 +128,18 @@ func ExtractFromPodSpec(spec *corev1.PodSpec, containerName string) []EnvVar {
 
 	return result
 }
+
+func isOptional(optional *bool) bool {
+	return optional != nil && *optional
+}
+
+// WithResource records the resource the env vars were extracted from in
+// their Origin
+func WithResource(envVars []EnvVar, kind, namespace, name string) []EnvVar {
+	for i := range envVars {
+		envVars[i].Origin.Kind = kind
+		envVars[i].Origin.Namespace = namespace
+		envVars[i].Origin.Name = name
+	}
+	return envVars
+}
@@ -1,13 +1,34 @@
 package extractor
 
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
 type EnvVar struct {
-	Name      string
-	Value     string
-	Source    EnvVarSource
-	IsSecret  bool
-	SecretRef *SecretKeyRef
-	ConfigRef *ConfigMapKeyRef
-	Prefix    string // Prefix for envFrom
+	Name             string
+	Value            string
+	Source           EnvVarSource
+	IsSecret         bool // Value is sensitive: read from a Secret, embedding one, or detected as one
+	SecretRef        *SecretKeyRef
+	ConfigRef        *ConfigMapKeyRef
+	FieldRef         *ObjectFieldRef
+	ResourceFieldRef *ResourceFieldRef
+	Prefix           string // Prefix for envFrom
+	EnvFrom          bool   // Imported with envFrom rather than listed in env
+	Redacted         bool   // Value was masked by redaction
+	Origin           Origin
+}
+
+// Origin identifies the resource and container an env var belongs to
+type Origin struct {
+	Kind          string
+	Namespace     string
+	Name          string
+	Container     string
+	InitContainer bool
 }
 
 type EnvVarSource int
@@ -16,18 +37,100 @@ const (
 	SourceDirect EnvVarSource = iota
 	SourceSecret
 	SourceConfigMap
+	SourceFieldRef
+	SourceResourceFieldRef
 )
 
+func (s EnvVarSource) String() string {
+	switch s {
+	case SourceDirect:
+		return "direct"
+	case SourceSecret:
+		return "secret"
+	case SourceConfigMap:
+		return "configMap"
+	case SourceFieldRef:
+		return "fieldRef"
+	case SourceResourceFieldRef:
+		return "resourceFieldRef"
+	default:
+		return fmt.Sprintf("EnvVarSource(%d)", int(s))
+	}
+}
+
 type SecretKeyRef struct {
-	Name string
-	Key  string
+	Name     string
+	Key      string
+	Optional bool
 }
 
 type ConfigMapKeyRef struct {
-	Name string
-	Key  string
+	Name     string
+	Key      string
+	Optional bool
+}
+
+// ObjectFieldRef selects a field of the pod, e.g. metadata.name or status.podIP
+type ObjectFieldRef struct {
+	FieldPath string
+}
+
+// ResourceFieldRef selects a resource of a container, e.g. limits.memory
+type ResourceFieldRef struct {
+	ContainerName string
+	Resource      string
+	Divisor       string
 }
 
 type Options struct {
 	Container string
+	// Kind, Name and Selector restrict extraction to matching workloads
+	Kind     string
+	Name     string
+	Selector string
+	// PodSpecPaths locates pod templates in kinds that are not built in
+	PodSpecPaths []PodSpecPath
+}
+
+// Result holds the environment variables extracted from a manifest stream
+// together with the workloads, Secrets and ConfigMaps found in the same stream.
+type Result struct {
+	EnvVars    []EnvVar
+	Workloads  []Workload
+	Secrets    []*corev1.Secret
+	ConfigMaps []*corev1.ConfigMap
+}
+
+// Workload is a resource with a pod template. Pod is the pod the template
+// describes, or the resource itself for a Pod. Object is the resource as it
+// was decoded.
+type Workload struct {
+	Kind      string
+	Namespace string
+	Name      string
+	Pod       *corev1.Pod
+	Object    runtime.Object
+}
+
+// FindContainer returns the named container of the pod, or its only regular
+// container when name is empty.
+func (w Workload) FindContainer(name string) (*corev1.Container, error) {
+	if name == "" {
+		if len(w.Pod.Spec.Containers) != 1 {
+			return nil, fmt.Errorf("%s/%s has %d containers, select one with --container", w.Kind, w.Name, len(w.Pod.Spec.Containers))
+		}
+		return &w.Pod.Spec.Containers[0], nil
+	}
+
+	for i := range w.Pod.Spec.InitContainers {
+		if w.Pod.Spec.InitContainers[i].Name == name {
+			return &w.Pod.Spec.InitContainers[i], nil
+		}
+	}
+	for i := range w.Pod.Spec.Containers {
+		if w.Pod.Spec.Containers[i].Name == name {
+			return &w.Pod.Spec.Containers[i], nil
+		}
+	}
+	return nil, fmt.Errorf("container %s not found in %s/%s", name, w.Kind, w.Name)
 }
@@ -0,0 +1,141 @@
+package fetcher
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/whywaita/keex/pkg/extractor"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Fetch reads a workload from the cluster. resourceType is a resource name
+// as kubectl accepts it, such as deployment, deploy or Deployment. The
+// returned selector matches the pods of the workload and is nil for kinds
+// that do not select pods.
+func Fetch(ctx context.Context, clientset kubernetes.Interface, namespace, resourceType, name string) (extractor.Workload, *metav1.LabelSelector, error) {
+	workload := extractor.Workload{Namespace: namespace, Name: name}
+	var selector *metav1.LabelSelector
+
+	switch strings.ToLower(resourceType) {
+	case "deployment", "deploy", "deployments":
+		deploy, err := clientset.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return extractor.Workload{}, nil, fmt.Errorf("failed to get deployment: %w", err)
+		}
+		workload.Kind = "Deployment"
+		workload.Object = deploy
+		workload.Pod = extractor.PodFromTemplate(deploy.ObjectMeta, &deploy.Spec.Template)
+		selector = deploy.Spec.Selector
+
+	case "statefulset", "sts", "statefulsets":
+		sts, err := clientset.AppsV1().StatefulSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return extractor.Workload{}, nil, fmt.Errorf("failed to get statefulset: %w", err)
+		}
+		workload.Kind = "StatefulSet"
+		workload.Object = sts
+		workload.Pod = extractor.PodFromTemplate(sts.ObjectMeta, &sts.Spec.Template)
+		selector = sts.Spec.Selector
+
+	case "daemonset", "ds", "daemonsets":
+		ds, err := clientset.AppsV1().DaemonSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return extractor.Workload{}, nil, fmt.Errorf("failed to get daemonset: %w", err)
+		}
+		workload.Kind = "DaemonSet"
+		workload.Object = ds
+		workload.Pod = extractor.PodFromTemplate(ds.ObjectMeta, &ds.Spec.Template)
+		selector = ds.Spec.Selector
+
+	case "replicaset", "rs", "replicasets":
+		rs, err := clientset.AppsV1().ReplicaSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return extractor.Workload{}, nil, fmt.Errorf("failed to get replicaset: %w", err)
+		}
+		workload.Kind = "ReplicaSet"
+		workload.Object = rs
+		workload.Pod = extractor.PodFromTemplate(rs.ObjectMeta, &rs.Spec.Template)
+		selector = rs.Spec.Selector
+
+	case "replicationcontroller", "rc", "replicationcontrollers":
+		rc, err := clientset.CoreV1().ReplicationControllers(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return extractor.Workload{}, nil, fmt.Errorf("failed to get replicationcontroller: %w", err)
+		}
+		if rc.Spec.Template == nil {
+			return extractor.Workload{}, nil, fmt.Errorf("replicationcontroller %s has no pod template", name)
+		}
+		workload.Kind = "ReplicationController"
+		workload.Object = rc
+		workload.Pod = extractor.PodFromTemplate(rc.ObjectMeta, rc.Spec.Template)
+		if len(rc.Spec.Selector) > 0 {
+			selector = &metav1.LabelSelector{MatchLabels: rc.Spec.Selector}
+		}
+
+	case "podtemplate", "podtemplates":
+		pt, err := clientset.CoreV1().PodTemplates(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return extractor.Workload{}, nil, fmt.Errorf("failed to get podtemplate: %w", err)
+		}
+		workload.Kind = "PodTemplate"
+		workload.Object = pt
+		workload.Pod = extractor.PodFromTemplate(pt.ObjectMeta, &pt.Template)
+
+	case "pod", "po", "pods":
+		pod, err := clientset.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return extractor.Workload{}, nil, fmt.Errorf("failed to get pod: %w", err)
+		}
+		workload.Kind = "Pod"
+		workload.Object = pod
+		workload.Pod = pod
+
+	case "job", "jobs":
+		job, err := clientset.BatchV1().Jobs(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return extractor.Workload{}, nil, fmt.Errorf("failed to get job: %w", err)
+		}
+		workload.Kind = "Job"
+		workload.Object = job
+		workload.Pod = extractor.PodFromTemplate(job.ObjectMeta, &job.Spec.Template)
+		selector = job.Spec.Selector
+
+	case "cronjob", "cj", "cronjobs":
+		cj, err := clientset.BatchV1().CronJobs(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return extractor.Workload{}, nil, fmt.Errorf("failed to get cronjob: %w", err)
+		}
+		workload.Kind = "CronJob"
+		workload.Object = cj
+		workload.Pod = extractor.PodFromTemplate(cj.ObjectMeta, &cj.Spec.JobTemplate.Spec.Template)
+
+	default:
+		return extractor.Workload{}, nil, fmt.Errorf("unsupported resource type: %s", resourceType)
+	}
+
+	return workload, selector, nil
+}
+
+// FindLivePod returns a running pod matching the selector, or nil if there is
+// none
+func FindLivePod(ctx context.Context, clientset kubernetes.Interface, namespace string, selector *metav1.LabelSelector) (*corev1.Pod, error) {
+	labelSelector, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid selector: %w", err)
+	}
+
+	pods, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: labelSelector.String()})
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range pods.Items {
+		if pods.Items[i].Status.Phase == corev1.PodRunning {
+			return &pods.Items[i], nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,76 @@
+package fetcher
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestFetch(t *testing.T) {
+	template := corev1.PodTemplateSpec{
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Env: []corev1.EnvVar{{Name: "FOO", Value: "bar"}}}}},
+	}
+	selector := &metav1.LabelSelector{MatchLabels: map[string]string{"app": "api"}}
+	clientset := fake.NewSimpleClientset(
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "prod"},
+			Spec:       appsv1.DeploymentSpec{Selector: selector, Template: template},
+		},
+		&batchv1.CronJob{
+			ObjectMeta: metav1.ObjectMeta{Name: "nightly", Namespace: "prod"},
+			Spec:       batchv1.CronJobSpec{JobTemplate: batchv1.JobTemplateSpec{Spec: batchv1.JobSpec{Template: template}}},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "api-1", Namespace: "prod", Labels: map[string]string{"app": "api"}},
+			Spec:       template.Spec,
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+		},
+	)
+	ctx := context.Background()
+
+	tests := []struct {
+		resourceType string
+		name         string
+		kind         string
+		hasSelector  bool
+	}{
+		{resourceType: "deploy", name: "api", kind: "Deployment", hasSelector: true},
+		{resourceType: "Deployment", name: "api", kind: "Deployment", hasSelector: true},
+		{resourceType: "cronjob", name: "nightly", kind: "CronJob"},
+		{resourceType: "po", name: "api-1", kind: "Pod"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.resourceType, func(t *testing.T) {
+			workload, gotSelector, err := Fetch(ctx, clientset, "prod", tt.resourceType, tt.name)
+			if err != nil {
+				t.Fatalf("Fetch() error = %v", err)
+			}
+			if workload.Kind != tt.kind || workload.Name != tt.name || workload.Namespace != "prod" || workload.Object == nil {
+				t.Errorf("Fetch() = %+v", workload)
+			}
+			if len(workload.Pod.Spec.Containers) != 1 || workload.Pod.Spec.Containers[0].Env[0].Value != "bar" {
+				t.Errorf("Fetch() pod = %+v", workload.Pod.Spec)
+			}
+			if (gotSelector != nil) != tt.hasSelector {
+				t.Errorf("Fetch() selector = %v, want set %v", gotSelector, tt.hasSelector)
+			}
+		})
+	}
+
+	if _, _, err := Fetch(ctx, clientset, "prod", "deployment", "missing"); err == nil {
+		t.Error("Fetch() expected error for a missing workload")
+	}
+	if _, _, err := Fetch(ctx, clientset, "prod", "service", "api"); err == nil {
+		t.Error("Fetch() expected error for an unsupported type")
+	}
+
+	pod, err := FindLivePod(ctx, clientset, "prod", selector)
+	if err != nil || pod == nil || pod.Name != "api-1" {
+		t.Errorf("FindLivePod() = %v, %v, want api-1", pod, err)
+	}
+}
@@ -0,0 +1,224 @@
+package formatter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/whywaita/keex/pkg/expander"
+	"github.com/whywaita/keex/pkg/extractor"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/yaml"
+)
+
+type composeFile struct {
+	Services map[string]composeService `json:"services"`
+}
+
+type composeService struct {
+	Image       string                       `json:"image"`
+	Entrypoint  []string                     `json:"entrypoint,omitempty"`
+	Command     []string                     `json:"command,omitempty"`
+	WorkingDir  string                       `json:"working_dir,omitempty"`
+	User        string                       `json:"user,omitempty"`
+	Ports       []string                     `json:"ports,omitempty"`
+	Environment map[string]string            `json:"environment,omitempty"`
+	Volumes     []string                     `json:"volumes,omitempty"`
+	DependsOn   map[string]composeDependency `json:"depends_on,omitempty"`
+	Healthcheck *composeHealthcheck          `json:"healthcheck,omitempty"`
+}
+
+type composeDependency struct {
+	Condition string `json:"condition"`
+}
+
+type composeHealthcheck struct {
+	Test        []string `json:"test"`
+	Interval    string   `json:"interval,omitempty"`
+	Timeout     string   `json:"timeout,omitempty"`
+	Retries     int32    `json:"retries,omitempty"`
+	StartPeriod string   `json:"start_period,omitempty"`
+}
+
+// FormatComposeService builds a compose file with one service per container
+// of the pod. Init containers become services the regular containers depend
+// on with service_completed_successfully, in the order the kubelet runs them.
+// envVars are assigned to services by their origin container and mounts are
+// keyed by container name. When containerName is set only that container is
+// emitted.
+func FormatComposeService(pod *corev1.Pod, containerName string, envVars []extractor.EnvVar, mounts map[string][]Mount) (string, error) {
+	file := composeFile{Services: make(map[string]composeService)}
+
+	var previousInit string
+	for i := range pod.Spec.InitContainers {
+		container := &pod.Spec.InitContainers[i]
+		if containerName != "" && container.Name != containerName {
+			continue
+		}
+
+		service := newComposeService(pod, container, envVars, mounts[container.Name])
+		if previousInit != "" {
+			service.DependsOn = map[string]composeDependency{
+				previousInit: {Condition: "service_completed_successfully"},
+			}
+		}
+		file.Services[container.Name] = service
+		previousInit = container.Name
+	}
+
+	for i := range pod.Spec.Containers {
+		container := &pod.Spec.Containers[i]
+		if containerName != "" && container.Name != containerName {
+			continue
+		}
+
+		service := newComposeService(pod, container, envVars, mounts[container.Name])
+		if previousInit != "" {
+			service.DependsOn = map[string]composeDependency{
+				previousInit: {Condition: "service_completed_successfully"},
+			}
+		}
+		service.Healthcheck = newComposeHealthcheck(container)
+		file.Services[container.Name] = service
+	}
+
+	if len(file.Services) == 0 {
+		return "", fmt.Errorf("container %s not found", containerName)
+	}
+
+	data, err := yaml.Marshal(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode compose file: %w", err)
+	}
+	return strings.TrimSuffix(string(data), "\n"), nil
+}
+
+func newComposeService(pod *corev1.Pod, container *corev1.Container, envVars []extractor.EnvVar, mounts []Mount) composeService {
+	service := composeService{
+		Image:      container.Image,
+		WorkingDir: container.WorkingDir,
+		User:       runAsUser(pod, container),
+	}
+
+	// command and args may reference env vars with $(VAR_NAME)
+	defined := make(map[string]string)
+	for _, env := range envVars {
+		if env.Origin.Container == container.Name {
+			defined[env.Name] = env.Value
+		}
+	}
+	expand := func(args []string) []string {
+		var expanded []string
+		for _, arg := range args {
+			arg = expander.Expand(arg, func(name string) (string, bool) {
+				value, ok := defined[name]
+				return value, ok
+			})
+			expanded = append(expanded, composeEscape(arg))
+		}
+		return expanded
+	}
+	service.Entrypoint = expand(container.Command)
+	service.Command = expand(container.Args)
+
+	for _, port := range container.Ports {
+		service.Ports = append(service.Ports, portMapping(port))
+	}
+
+	for _, env := range envVars {
+		// Skip comment entries and other containers
+		if strings.HasPrefix(env.Name, "#") || env.Origin.Container != container.Name {
+			continue
+		}
+		if service.Environment == nil {
+			service.Environment = make(map[string]string)
+		}
+		service.Environment[env.Name] = composeEscape(env.Value)
+	}
+
+	for _, mount := range mounts {
+		volume := mount.HostPath + ":" + mount.ContainerPath
+		if mount.ReadOnly {
+			volume += ":ro"
+		}
+		service.Volumes = append(service.Volumes, volume)
+	}
+
+	return service
+}
+
+// newComposeHealthcheck derives a healthcheck from the readiness probe, or
+// the liveness probe if there is none. Only exec and httpGet probes can be
+// expressed as a compose healthcheck.
+func newComposeHealthcheck(container *corev1.Container) *composeHealthcheck {
+	probe := container.ReadinessProbe
+	if probe == nil || (probe.Exec == nil && probe.HTTPGet == nil) {
+		probe = container.LivenessProbe
+	}
+	if probe == nil {
+		return nil
+	}
+
+	healthcheck := &composeHealthcheck{}
+	switch {
+	case probe.Exec != nil:
+		healthcheck.Test = append([]string{"CMD"}, probe.Exec.Command...)
+	case probe.HTTPGet != nil:
+		port, ok := resolvePort(container, probe.HTTPGet.Port)
+		if !ok {
+			return nil
+		}
+		scheme := strings.ToLower(string(probe.HTTPGet.Scheme))
+		if scheme == "" {
+			scheme = "http"
+		}
+		host := probe.HTTPGet.Host
+		if host == "" {
+			host = "localhost"
+		}
+		url := fmt.Sprintf("%s://%s:%d%s", scheme, host, port, probe.HTTPGet.Path)
+		healthcheck.Test = []string{"CMD-SHELL", fmt.Sprintf("curl -fsS %s || exit 1", quotePOSIX(url))}
+	default:
+		return nil
+	}
+	// The test is interpolated like every other compose value
+	for i, arg := range healthcheck.Test {
+		healthcheck.Test[i] = composeEscape(arg)
+	}
+
+	if probe.PeriodSeconds > 0 {
+		healthcheck.Interval = fmt.Sprintf("%ds", probe.PeriodSeconds)
+	}
+	if probe.TimeoutSeconds > 0 {
+		healthcheck.Timeout = fmt.Sprintf("%ds", probe.TimeoutSeconds)
+	}
+	if probe.InitialDelaySeconds > 0 {
+		healthcheck.StartPeriod = fmt.Sprintf("%ds", probe.InitialDelaySeconds)
+	}
+	healthcheck.Retries = probe.FailureThreshold
+
+	return healthcheck
+}
+
+// resolvePort returns the port number of a probe port, looking up named ports
+// in the container
+func resolvePort(container *corev1.Container, port intstr.IntOrString) (int32, bool) {
+	if port.Type == intstr.Int {
+		return port.IntVal, true
+	}
+	if n, err := strconv.Atoi(port.StrVal); err == nil {
+		return int32(n), true
+	}
+	for _, p := range container.Ports {
+		if p.Name == port.StrVal {
+			return p.ContainerPort, true
+		}
+	}
+	return 0, false
+}
+
+// composeEscape escapes $ so compose does not interpolate the value
+func composeEscape(s string) string {
+	return strings.ReplaceAll(s, "$", "$$")
+}
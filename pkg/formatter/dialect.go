@@ -0,0 +1,94 @@
+package formatter
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Dialect is a KEY=value file syntax. Each consumer of env files has its own
+// quoting and interpolation rules, so a value is written for one of them.
+type Dialect string
+
+const (
+	// DialectCompose is the .env syntax of docker compose: double quotes with
+	// backslash escapes, and $ written as $$ to prevent interpolation
+	DialectCompose Dialect = "compose"
+	// DialectGodotenv is the syntax of godotenv: double quotes with backslash
+	// escapes, and $ written as \$ to prevent expansion
+	DialectGodotenv Dialect = "godotenv"
+	// DialectDockerEnvFile is the syntax of docker run --env-file, which takes
+	// everything after = literally and cannot hold line breaks
+	DialectDockerEnvFile Dialect = "docker"
+	// DialectShell is POSIX shell syntax with single-quoted values
+	DialectShell Dialect = "shell"
+)
+
+// Dialects lists the supported dialects
+var Dialects = []Dialect{DialectCompose, DialectGodotenv, DialectDockerEnvFile, DialectShell}
+
+// ParseDialect returns the dialect with the given name
+func ParseDialect(name string) (Dialect, error) {
+	for _, dialect := range Dialects {
+		if string(dialect) == name {
+			return dialect, nil
+		}
+	}
+	return "", fmt.Errorf("invalid dialect: %s (must be one of %s)", name, strings.Join(DialectNames(), ", "))
+}
+
+// DialectNames returns the names of the supported dialects
+func DialectNames() []string {
+	names := make([]string, len(Dialects))
+	for i, dialect := range Dialects {
+		names[i] = string(dialect)
+	}
+	return names
+}
+
+// Quote returns value in the syntax of the dialect, including quotes. It
+// fails when the dialect cannot represent the value.
+func (d Dialect) Quote(value string) (string, error) {
+	switch d {
+	case DialectCompose:
+		return `"` + escapeDoubleQuoted(value, "$$") + `"`, nil
+	case DialectGodotenv:
+		return `"` + escapeDoubleQuoted(value, `\$`) + `"`, nil
+	case DialectDockerEnvFile:
+		if strings.ContainsAny(value, "\r\n") {
+			return "", fmt.Errorf("docker env files cannot hold values with line breaks")
+		}
+		return value, nil
+	case DialectShell:
+		return quotePOSIX(value), nil
+	default:
+		return "", fmt.Errorf("unknown dialect: %s", d)
+	}
+}
+
+// escapeDoubleQuoted escapes value for a double-quoted dotenv string, with
+// dollar as the replacement for $
+func escapeDoubleQuoted(value, dollar string) string {
+	var b strings.Builder
+	for _, r := range value {
+		switch r {
+		case '\\':
+			b.WriteString(`\\`)
+		case '"':
+			b.WriteString(`\"`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '$':
+			b.WriteString(dollar)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// quotePOSIX wraps value in single quotes, which the shell takes literally
+func quotePOSIX(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
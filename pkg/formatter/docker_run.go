@@ -0,0 +1,140 @@
+package formatter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/whywaita/keex/pkg/expander"
+	"github.com/whywaita/keex/pkg/extractor"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Mount is a host path bind mounted into the container
+type Mount struct {
+	HostPath      string
+	ContainerPath string
+	ReadOnly      bool
+}
+
+// FormatMounts formats bind mounts as -v flags for docker run
+func FormatMounts(mounts []Mount) string {
+	var parts []string
+
+	for _, mount := range mounts {
+		volume := mount.HostPath + ":" + mount.ContainerPath
+		if mount.ReadOnly {
+			volume += ":ro"
+		}
+		parts = append(parts, "-v "+quotePOSIX(volume))
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// FormatDockerRun builds a complete run command for a container engine such
+// as docker or podman. The image, command, args, working directory, ports,
+// user and resource limits come from the container spec, the environment
+// from envVars, formatted like FormatDocker, and the volumes from mounts.
+func FormatDockerRun(engine string, pod *corev1.Pod, container *corev1.Container, envVars []extractor.EnvVar, mounts []Mount) string {
+	parts := []string{engine, "run", "--rm"}
+
+	if container.Stdin {
+		parts = append(parts, "-i")
+	}
+	if container.TTY {
+		parts = append(parts, "-t")
+	}
+	if container.WorkingDir != "" {
+		parts = append(parts, "-w", quotePOSIX(container.WorkingDir))
+	}
+	if user := runAsUser(pod, container); user != "" {
+		parts = append(parts, "-u", user)
+	}
+
+	for _, port := range container.Ports {
+		parts = append(parts, "-p", portMapping(port))
+	}
+
+	if memory, ok := container.Resources.Limits[corev1.ResourceMemory]; ok {
+		parts = append(parts, "--memory", strconv.FormatInt(memory.Value(), 10))
+	}
+	if cpu, ok := container.Resources.Limits[corev1.ResourceCPU]; ok {
+		parts = append(parts, "--cpus", strconv.FormatFloat(float64(cpu.MilliValue())/1000, 'f', -1, 64))
+	}
+
+	if volumes := FormatMounts(mounts); volumes != "" {
+		parts = append(parts, volumes)
+	}
+	if env := FormatDocker(envVars); env != "" {
+		parts = append(parts, env)
+	}
+
+	// command and args may reference env vars with $(VAR_NAME)
+	defined := make(map[string]string, len(envVars))
+	for _, env := range envVars {
+		defined[env.Name] = env.Value
+	}
+	expand := func(s string) string {
+		return expander.Expand(s, func(name string) (string, bool) {
+			value, ok := defined[name]
+			return value, ok
+		})
+	}
+
+	// command replaces the image entrypoint; docker only takes the executable
+	// there, so the rest of command goes before args
+	var trailing []string
+	if len(container.Command) > 0 {
+		parts = append(parts, "--entrypoint", quotePOSIX(expand(container.Command[0])))
+		trailing = append(trailing, container.Command[1:]...)
+	}
+	trailing = append(trailing, container.Args...)
+
+	parts = append(parts, quotePOSIX(container.Image))
+	for _, arg := range trailing {
+		parts = append(parts, quotePOSIX(expand(arg)))
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// portMapping publishes a container port on the same host port unless the
+// spec sets a hostPort
+func portMapping(port corev1.ContainerPort) string {
+	hostPort := port.HostPort
+	if hostPort == 0 {
+		hostPort = port.ContainerPort
+	}
+	mapping := fmt.Sprintf("%d:%d", hostPort, port.ContainerPort)
+	if port.Protocol != "" && port.Protocol != corev1.ProtocolTCP {
+		mapping += "/" + strings.ToLower(string(port.Protocol))
+	}
+	return mapping
+}
+
+// runAsUser returns the user the container runs as, preferring the container
+// security context over the pod security context
+func runAsUser(pod *corev1.Pod, container *corev1.Container) string {
+	var uid, gid *int64
+	if pod.Spec.SecurityContext != nil {
+		uid = pod.Spec.SecurityContext.RunAsUser
+		gid = pod.Spec.SecurityContext.RunAsGroup
+	}
+	if container.SecurityContext != nil {
+		if container.SecurityContext.RunAsUser != nil {
+			uid = container.SecurityContext.RunAsUser
+		}
+		if container.SecurityContext.RunAsGroup != nil {
+			gid = container.SecurityContext.RunAsGroup
+		}
+	}
+
+	if uid == nil {
+		return ""
+	}
+	if gid == nil {
+		return strconv.FormatInt(*uid, 10)
+	}
+	return fmt.Sprintf("%d:%d", *uid, *gid)
+}
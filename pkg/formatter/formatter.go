@@ -1,33 +1,31 @@
 package formatter
 
 import (
+	"encoding/json"
 	"fmt"
 	"strings"
+	"unicode"
 
 	"github.com/whywaita/keex/pkg/extractor"
 )
 
-func FormatDocker(envVars []extractor.EnvVar, redact bool) string {
+func FormatDocker(envVars []extractor.EnvVar) string {
 	var parts []string
 
 	for _, env := range envVars {
-		value := env.Value
-		if redact && env.IsSecret {
-			value = "***REDACTED***"
+		// Skip comment entries
+		if strings.HasPrefix(env.Name, "#") {
+			continue
 		}
-		// Escape double quotes in value for docker
-		value = strings.ReplaceAll(value, `"`, `\"`)
-		parts = append(parts, fmt.Sprintf(`-e %s="%s"`, env.Name, value))
+
+		// Single quotes keep the shell from expanding $ and backticks
+		parts = append(parts, fmt.Sprintf(`-e %s=%s`, env.Name, quotePOSIX(env.Value)))
 	}
 
 	return strings.Join(parts, " ")
 }
 
-func FormatShell(envVars []extractor.EnvVar, export bool, redact ...bool) string {
-	shouldRedact := false
-	if len(redact) > 0 {
-		shouldRedact = redact[0]
-	}
+func FormatShell(envVars []extractor.EnvVar, export bool) string {
 	var parts []string
 
 	for _, env := range envVars {
@@ -36,24 +34,33 @@ func FormatShell(envVars []extractor.EnvVar, export bool, redact ...bool) string
 			continue
 		}
 
-		value := env.Value
-		if shouldRedact && env.IsSecret {
-			value = "***REDACTED***"
-		}
-		// Escape single quotes in value for shell
-		value = strings.ReplaceAll(value, `'`, `'\''`)
-
 		if export {
-			parts = append(parts, fmt.Sprintf(`export %s='%s'`, env.Name, value))
+			parts = append(parts, fmt.Sprintf(`export %s=%s`, env.Name, quotePOSIX(env.Value)))
 		} else {
-			parts = append(parts, fmt.Sprintf(`%s='%s'`, env.Name, value))
+			parts = append(parts, fmt.Sprintf(`%s=%s`, env.Name, quotePOSIX(env.Value)))
 		}
 	}
 
 	return strings.Join(parts, "\n")
 }
 
-func FormatDotenv(envVars []extractor.EnvVar) string {
+// FormatDirenv writes an .envrc that exports envVars. When manifestPath is
+// set direnv reloads the file whenever the manifest changes.
+func FormatDirenv(envVars []extractor.EnvVar, manifestPath string) string {
+	var parts []string
+	if manifestPath != "" {
+		parts = append(parts, "watch_file "+quotePOSIX(manifestPath))
+	}
+	if exports := FormatShell(envVars, true); exports != "" {
+		parts = append(parts, exports)
+	}
+	return strings.Join(parts, "\n")
+}
+
+// FormatSystemd writes a file for the EnvironmentFile= directive of systemd.
+// Values are double-quoted, where systemd takes newlines literally and
+// unescapes \", \\, \` and \$.
+func FormatSystemd(envVars []extractor.EnvVar) string {
 	var parts []string
 
 	for _, env := range envVars {
@@ -62,15 +69,51 @@ func FormatDotenv(envVars []extractor.EnvVar) string {
 			continue
 		}
 
-		value := env.Value
-		// Escape double quotes in value for dotenv
-		value = strings.ReplaceAll(value, `"`, `\"`)
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "`", "\\`", "$", `\$`).Replace(env.Value)
 		parts = append(parts, fmt.Sprintf(`%s="%s"`, env.Name, value))
 	}
 
 	return strings.Join(parts, "\n")
 }
 
+// FormatSystemdDropin writes a unit drop-in with an Environment= line per
+// variable. Unit files use C-style escapes in quotes and expand % specifiers.
+func FormatSystemdDropin(envVars []extractor.EnvVar) string {
+	parts := []string{"[Service]"}
+
+	for _, env := range envVars {
+		// Skip comment entries
+		if strings.HasPrefix(env.Name, "#") {
+			continue
+		}
+
+		assignment := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`, "%", "%%").Replace(env.Name + "=" + env.Value)
+		parts = append(parts, fmt.Sprintf(`Environment="%s"`, assignment))
+	}
+
+	return strings.Join(parts, "\n")
+}
+
+// FormatDotenv writes envVars as a KEY=value file in the given dialect
+func FormatDotenv(envVars []extractor.EnvVar, dialect Dialect) (string, error) {
+	var parts []string
+
+	for _, env := range envVars {
+		// Skip comment entries
+		if strings.HasPrefix(env.Name, "#") {
+			continue
+		}
+
+		value, err := dialect.Quote(env.Value)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", env.Name, err)
+		}
+		parts = append(parts, fmt.Sprintf(`%s=%s`, env.Name, value))
+	}
+
+	return strings.Join(parts, "\n"), nil
+}
+
 func FormatCompose(envVars []extractor.EnvVar) string {
 	var parts []string
 	parts = append(parts, "environment:")
@@ -81,21 +124,41 @@ func FormatCompose(envVars []extractor.EnvVar) string {
 			continue
 		}
 
-		value := env.Value
+		// Compose interpolates $, escape it to keep the value literal
+		value := strings.ReplaceAll(env.Value, "$", "$$")
 		// For compose format, we need to handle multi-line values
-		if strings.Contains(value, "\n") {
-			// Use literal style for multi-line values
-			parts = append(parts, fmt.Sprintf("  %s: |", env.Name))
+		// A trailing newline of a block scalar is lost at the end of the
+		// document, so those values are quoted instead
+		if strings.Contains(value, "\n") && !strings.HasSuffix(value, "\n") && !strings.ContainsFunc(value, isNonPrintable) {
+			// Use literal style for multi-line values. The indentation
+			// indicator allows leading spaces and strip chomping keeps the
+			// value from gaining a trailing newline.
+			parts = append(parts, fmt.Sprintf("  %s: |2-", env.Name))
 			lines := strings.Split(value, "\n")
 			for _, line := range lines {
 				parts = append(parts, fmt.Sprintf("    %s", line))
 			}
 		} else {
-			// Single line values with quotes
-			value = strings.ReplaceAll(value, `"`, `\"`)
-			parts = append(parts, fmt.Sprintf(`  %s: "%s"`, env.Name, value))
+			// Single line values as JSON strings, which YAML reads as
+			// double-quoted scalars
+			parts = append(parts, fmt.Sprintf(`  %s: %s`, env.Name, quoteYAML(value)))
 		}
 	}
 
 	return strings.Join(parts, "\n")
 }
+
+// quoteYAML returns value as a double-quoted YAML scalar
+func quoteYAML(value string) string {
+	var b strings.Builder
+	encoder := json.NewEncoder(&b)
+	encoder.SetEscapeHTML(false)
+	// Encoding a string cannot fail
+	_ = encoder.Encode(value)
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// isNonPrintable reports whether r cannot appear in a YAML block scalar as is
+func isNonPrintable(r rune) bool {
+	return r != '\n' && r != '\t' && !unicode.IsPrint(r)
+}
@@ -1,16 +1,19 @@
 package formatter
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/whywaita/keex/pkg/extractor"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 func TestFormatDocker(t *testing.T) {
 	tests := []struct {
 		name     string
 		envVars  []extractor.EnvVar
-		redact   bool
 		expected string
 	}{
 		{
@@ -19,31 +22,27 @@ func TestFormatDocker(t *testing.T) {
 				{Name: "FOO", Value: "bar", Source: extractor.SourceDirect},
 				{Name: "BAZ", Value: "qux", Source: extractor.SourceDirect},
 			},
-			redact:   false,
-			expected: `-e FOO="bar" -e BAZ="qux"`,
+			expected: `-e FOO='bar' -e BAZ='qux'`,
 		},
 		{
 			name: "with quotes",
 			envVars: []extractor.EnvVar{
 				{Name: "MESSAGE", Value: `hello "world"`, Source: extractor.SourceDirect},
 			},
-			redact:   false,
-			expected: `-e MESSAGE="hello \"world\""`,
+			expected: `-e MESSAGE='hello "world"'`,
 		},
 		{
-			name: "redacted secrets",
+			name: "with shell expansions",
 			envVars: []extractor.EnvVar{
-				{Name: "PASSWORD", Value: "secret123", Source: extractor.SourceSecret, IsSecret: true},
-				{Name: "USER", Value: "admin", Source: extractor.SourceDirect},
+				{Name: "PRICE", Value: "$5 `date` it's", Source: extractor.SourceDirect},
 			},
-			redact:   true,
-			expected: `-e PASSWORD="***REDACTED***" -e USER="admin"`,
+			expected: `-e PRICE='$5 ` + "`date`" + ` it'\''s'`,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := FormatDocker(tt.envVars, tt.redact)
+			result := FormatDocker(tt.envVars)
 			if result != tt.expected {
 				t.Errorf("FormatDocker() = %q, want %q", result, tt.expected)
 			}
@@ -55,7 +54,6 @@ func TestFormatShellOneLine(t *testing.T) {
 	tests := []struct {
 		name     string
 		envVars  []extractor.EnvVar
-		redact   bool
 		expected string
 	}{
 		{
@@ -64,7 +62,6 @@ func TestFormatShellOneLine(t *testing.T) {
 				{Name: "FOO", Value: "bar", Source: extractor.SourceDirect},
 				{Name: "BAZ", Value: "qux", Source: extractor.SourceDirect},
 			},
-			redact: false,
 			expected: `FOO='bar'
 BAZ='qux'`,
 		},
@@ -73,27 +70,223 @@ BAZ='qux'`,
 			envVars: []extractor.EnvVar{
 				{Name: "MESSAGE", Value: `hello 'world'`, Source: extractor.SourceDirect},
 			},
-			redact:   false,
 			expected: `MESSAGE='hello '\''world'\'''`,
 		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := FormatShell(tt.envVars, false)
+			if result != tt.expected {
+				t.Errorf("FormatShell() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestFormatDockerRun(t *testing.T) {
+	uid := int64(1000)
+	gid := int64(2000)
+
+	pod := &corev1.Pod{
+		Spec: corev1.PodSpec{
+			SecurityContext: &corev1.PodSecurityContext{RunAsUser: &uid},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		engine    string
+		container *corev1.Container
+		envVars   []extractor.EnvVar
+		mounts    []Mount
+		expected  string
+	}{
 		{
-			name: "redacted secrets",
+			name:      "image only",
+			engine:    "docker",
+			container: &corev1.Container{Image: "nginx:1.27"},
+			expected:  `docker run --rm -u 1000 'nginx:1.27'`,
+		},
+		{
+			name:   "full container spec",
+			engine: "podman",
+			container: &corev1.Container{
+				Image:      "ghcr.io/example/app:v1",
+				Command:    []string{"/bin/app", "serve"},
+				Args:       []string{"--listen", ":$(PORT)", "--name", "my app"},
+				WorkingDir: "/srv",
+				Ports: []corev1.ContainerPort{
+					{ContainerPort: 8080},
+					{ContainerPort: 5353, HostPort: 53, Protocol: corev1.ProtocolUDP},
+				},
+				SecurityContext: &corev1.SecurityContext{RunAsUser: &uid, RunAsGroup: &gid},
+				Resources: corev1.ResourceRequirements{
+					Limits: corev1.ResourceList{
+						corev1.ResourceMemory: resource.MustParse("512Mi"),
+						corev1.ResourceCPU:    resource.MustParse("500m"),
+					},
+				},
+			},
 			envVars: []extractor.EnvVar{
-				{Name: "PASSWORD", Value: "secret123", Source: extractor.SourceSecret, IsSecret: true},
-				{Name: "USER", Value: "admin", Source: extractor.SourceDirect},
+				{Name: "PORT", Value: "8080", Source: extractor.SourceDirect},
+			},
+			mounts: []Mount{
+				{HostPath: "/tmp/keex/tls", ContainerPath: "/etc/tls", ReadOnly: true},
 			},
-			redact: true,
-			expected: `PASSWORD='***REDACTED***'
-USER='admin'`,
+			expected: `podman run --rm -w '/srv' -u 1000:2000 -p 8080:8080 -p 53:5353/udp --memory 536870912 --cpus 0.5 -v '/tmp/keex/tls:/etc/tls:ro' -e PORT='8080' --entrypoint '/bin/app' 'ghcr.io/example/app:v1' 'serve' '--listen' ':8080' '--name' 'my app'`,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := FormatShell(tt.envVars, false, tt.redact)
+			result := FormatDockerRun(tt.engine, pod, tt.container, tt.envVars, tt.mounts)
 			if result != tt.expected {
-				t.Errorf("FormatShell() = %q, want %q", result, tt.expected)
+				t.Errorf("FormatDockerRun() = %q, want %q", result, tt.expected)
 			}
 		})
 	}
 }
+
+func TestFormatComposeService(t *testing.T) {
+	pod := &corev1.Pod{
+		Spec: corev1.PodSpec{
+			InitContainers: []corev1.Container{
+				{Name: "migrate", Image: "app:v1", Command: []string{"/bin/migrate"}},
+			},
+			Containers: []corev1.Container{
+				{
+					Name:    "app",
+					Image:   "app:v1",
+					Command: []string{"/bin/app"},
+					Args:    []string{"--port", "$(PORT)"},
+					Ports:   []corev1.ContainerPort{{Name: "http", ContainerPort: 8080}},
+					ReadinessProbe: &corev1.Probe{
+						ProbeHandler: corev1.ProbeHandler{
+							HTTPGet: &corev1.HTTPGetAction{Path: "/healthz", Port: intstr.FromString("http")},
+						},
+						PeriodSeconds:    10,
+						FailureThreshold: 3,
+					},
+				},
+				{
+					Name:  "sidecar",
+					Image: "proxy:v2",
+					LivenessProbe: &corev1.Probe{
+						ProbeHandler: corev1.ProbeHandler{
+							Exec: &corev1.ExecAction{Command: []string{"sh", "-c", `test -n "$HOME"`}},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	envVars := []extractor.EnvVar{
+		{Name: "PORT", Value: "8080", Source: extractor.SourceDirect, Origin: extractor.Origin{Container: "app"}},
+		{Name: "PASSWORD", Value: "pa$$", Source: extractor.SourceSecret, IsSecret: true, Origin: extractor.Origin{Container: "app"}},
+		{Name: "DB_URL", Value: "postgres://db", Source: extractor.SourceDirect, Origin: extractor.Origin{Container: "migrate", InitContainer: true}},
+	}
+
+	mounts := map[string][]Mount{
+		"app": {{HostPath: "/tmp/keex/tls", ContainerPath: "/etc/tls", ReadOnly: true}},
+	}
+
+	expected := `services:
+  app:
+    command:
+    - --port
+    - "8080"
+    depends_on:
+      migrate:
+        condition: service_completed_successfully
+    entrypoint:
+    - /bin/app
+    environment:
+      PASSWORD: pa$$$$
+      PORT: "8080"
+    healthcheck:
+      interval: 10s
+      retries: 3
+      test:
+      - CMD-SHELL
+      - curl -fsS 'http://localhost:8080/healthz' || exit 1
+    image: app:v1
+    ports:
+    - 8080:8080
+    volumes:
+    - /tmp/keex/tls:/etc/tls:ro
+  migrate:
+    entrypoint:
+    - /bin/migrate
+    environment:
+      DB_URL: postgres://db
+    image: app:v1
+  sidecar:
+    depends_on:
+      migrate:
+        condition: service_completed_successfully
+    healthcheck:
+      test:
+      - CMD
+      - sh
+      - -c
+      - test -n "$$HOME"
+    image: proxy:v2`
+
+	result, err := FormatComposeService(pod, "", envVars, mounts)
+	if err != nil {
+		t.Fatalf("FormatComposeService() error = %v", err)
+	}
+	if result != expected {
+		t.Errorf("FormatComposeService() =\n%s\nwant\n%s", result, expected)
+	}
+
+	single, err := FormatComposeService(pod, "app", envVars, nil)
+	if err != nil {
+		t.Fatalf("FormatComposeService() error = %v", err)
+	}
+	if !strings.Contains(single, "  app:") || strings.Contains(single, "sidecar") {
+		t.Errorf("FormatComposeService() with container =\n%s", single)
+	}
+
+	if _, err := FormatComposeService(pod, "missing", envVars, nil); err == nil {
+		t.Error("FormatComposeService() expected error for unknown container")
+	}
+}
+
+func TestFormatDirenv(t *testing.T) {
+	envVars := []extractor.EnvVar{
+		{Name: "FOO", Value: "bar", Source: extractor.SourceDirect},
+		{Name: "MESSAGE", Value: "it's", Source: extractor.SourceDirect},
+	}
+
+	expected := `watch_file '/srv/my app/deploy.yaml'
+export FOO='bar'
+export MESSAGE='it'\''s'`
+	if result := FormatDirenv(envVars, "/srv/my app/deploy.yaml"); result != expected {
+		t.Errorf("FormatDirenv() = %q, want %q", result, expected)
+	}
+
+	if result := FormatDirenv(envVars[:1], ""); result != "export FOO='bar'" {
+		t.Errorf("FormatDirenv() without manifest = %q", result)
+	}
+}
+
+func TestFormatSystemd(t *testing.T) {
+	envVars := []extractor.EnvVar{
+		{Name: "FOO", Value: "bar", Source: extractor.SourceDirect},
+		{Name: "SPECIAL", Value: "a \"b\" \\ `c` $d 100%", Source: extractor.SourceDirect},
+		{Name: "MULTI", Value: "line1\nline2", Source: extractor.SourceDirect},
+	}
+
+	expected := "FOO=\"bar\"\nSPECIAL=\"a \\\"b\\\" \\\\ \\`c\\` \\$d 100%\"\nMULTI=\"line1\nline2\""
+	if result := FormatSystemd(envVars); result != expected {
+		t.Errorf("FormatSystemd() = %q, want %q", result, expected)
+	}
+
+	expected = "[Service]\nEnvironment=\"FOO=bar\"\nEnvironment=\"SPECIAL=a \\\"b\\\" \\\\ `c` $d 100%%\"\nEnvironment=\"MULTI=line1\\nline2\""
+	if result := FormatSystemdDropin(envVars); result != expected {
+		t.Errorf("FormatSystemdDropin() = %q, want %q", result, expected)
+	}
+}
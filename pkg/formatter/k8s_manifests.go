@@ -0,0 +1,271 @@
+package formatter
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/whywaita/keex/pkg/extractor"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+)
+
+// manifestScheme knows the built-in workload kinds so that objects read from
+// the API server, which come without apiVersion and kind, can be written out
+var manifestScheme = func() *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	_ = appsv1.AddToScheme(scheme)
+	_ = batchv1.AddToScheme(scheme)
+	_ = corev1.AddToScheme(scheme)
+	return scheme
+}()
+
+// FormatK8sManifests flattens the resolved environment of a workload into a
+// ConfigMap with the direct and ConfigMap values, a Secret with the Secret
+// values, and a copy of the workload whose containers load both with envFrom
+// instead of listing env entries. fieldRef and resourceFieldRef entries, and
+// env entries that reference them, stay inline because their values differ
+// per pod. envFrom sources that could not be resolved are kept as they were.
+//
+// name is the name of the ConfigMap and Secret and defaults to the workload
+// name; when several containers have env vars the container name is appended.
+// namespace replaces the namespace of all objects when set. When containerName
+// is set only that container is patched. Redacted values are rejected, the
+// manifests would deploy the masked values.
+func FormatK8sManifests(workload *extractor.Workload, containerName string, envVars []extractor.EnvVar, name, namespace string) (string, error) {
+	if workload.Object == nil {
+		return "", fmt.Errorf("%s/%s has no manifest to patch", workload.Kind, workload.Name)
+	}
+	for _, env := range envVars {
+		if env.Redacted {
+			return "", fmt.Errorf("%s is redacted, k8s-manifests needs the real values and can not be used with redaction", env.Name)
+		}
+	}
+	object := workload.Object.DeepCopyObject()
+	spec, err := podSpecOf(object)
+	if err != nil {
+		return "", err
+	}
+
+	if name == "" {
+		name = workload.Name
+	}
+	if namespace == "" {
+		namespace = workload.Namespace
+	}
+
+	var containers []*corev1.Container
+	for i := range spec.InitContainers {
+		containers = append(containers, &spec.InitContainers[i])
+	}
+	for i := range spec.Containers {
+		containers = append(containers, &spec.Containers[i])
+	}
+
+	// Only containers with env vars are patched
+	byContainer := make(map[string][]extractor.EnvVar)
+	var patched []*corev1.Container
+	for _, container := range containers {
+		if containerName != "" && container.Name != containerName {
+			continue
+		}
+		for _, env := range envVars {
+			if env.Origin.Container == container.Name {
+				byContainer[container.Name] = append(byContainer[container.Name], env)
+			}
+		}
+		if len(byContainer[container.Name]) > 0 {
+			patched = append(patched, container)
+		}
+	}
+	if containerName != "" && len(patched) == 0 {
+		if _, err := workload.FindContainer(containerName); err != nil {
+			return "", err
+		}
+	}
+
+	var documents []runtime.Object
+	for _, container := range patched {
+		objectName := name + "-env"
+		if len(patched) > 1 {
+			objectName = name + "-" + container.Name + "-env"
+		}
+
+		configMap, secret := flattenContainerEnv(container, byContainer[container.Name], objectName, namespace)
+		if configMap != nil {
+			documents = append(documents, configMap)
+		}
+		if secret != nil {
+			documents = append(documents, secret)
+		}
+	}
+
+	accessor, ok := object.(metav1.Object)
+	if !ok {
+		return "", fmt.Errorf("%s/%s has no metadata", workload.Kind, workload.Name)
+	}
+	accessor.SetNamespace(namespace)
+	documents = append(documents, object)
+
+	var parts []string
+	for _, document := range documents {
+		manifest, err := toManifest(document)
+		if err != nil {
+			return "", err
+		}
+		data, err := yaml.Marshal(manifest)
+		if err != nil {
+			return "", fmt.Errorf("failed to encode manifest: %w", err)
+		}
+		parts = append(parts, strings.TrimSuffix(string(data), "\n"))
+	}
+	return strings.Join(parts, "\n---\n"), nil
+}
+
+// flattenContainerEnv moves the env vars of the container into a ConfigMap
+// and a Secret and rewrites its env and envFrom to load them. Either object is
+// nil when it would be empty.
+func flattenContainerEnv(container *corev1.Container, envVars []extractor.EnvVar, name, namespace string) (*corev1.ConfigMap, *corev1.Secret) {
+	// Per-pod values and entries that reference them stay inline
+	dynamic := make(map[string]bool)
+	var inline []corev1.EnvVar
+	for _, env := range container.Env {
+		if env.ValueFrom != nil && (env.ValueFrom.FieldRef != nil || env.ValueFrom.ResourceFieldRef != nil) {
+			dynamic[env.Name] = true
+			inline = append(inline, env)
+			continue
+		}
+		if env.ValueFrom == nil && referencesAny(env.Value, dynamic) {
+			dynamic[env.Name] = true
+			inline = append(inline, env)
+		}
+	}
+
+	// envFrom sources that could not be expanded are kept as they were
+	unresolved := make(map[string]bool)
+	configData := make(map[string]string)
+	secretData := make(map[string][]byte)
+	for _, env := range envVars {
+		switch {
+		case strings.HasPrefix(env.Name, "#"):
+			if env.SecretRef != nil {
+				unresolved["secret/"+env.SecretRef.Name] = true
+			} else if env.ConfigRef != nil {
+				unresolved["configmap/"+env.ConfigRef.Name] = true
+			}
+		case dynamic[env.Name]:
+		case env.IsSecret || env.Source == extractor.SourceSecret:
+			// Values read from a Secret, embedding one or detected as one
+			secretData[env.Name] = []byte(env.Value)
+		default:
+			configData[env.Name] = env.Value
+		}
+	}
+
+	var envFrom []corev1.EnvFromSource
+	for _, source := range container.EnvFrom {
+		if (source.SecretRef != nil && unresolved["secret/"+source.SecretRef.Name]) ||
+			(source.ConfigMapRef != nil && unresolved["configmap/"+source.ConfigMapRef.Name]) {
+			envFrom = append(envFrom, source)
+		}
+	}
+
+	var configMap *corev1.ConfigMap
+	if len(configData) > 0 {
+		configMap = &corev1.ConfigMap{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Data:       configData,
+		}
+		envFrom = append(envFrom, corev1.EnvFromSource{
+			ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: name}},
+		})
+	}
+
+	var secret *corev1.Secret
+	if len(secretData) > 0 {
+		secret = &corev1.Secret{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Type:       corev1.SecretTypeOpaque,
+			Data:       secretData,
+		}
+		envFrom = append(envFrom, corev1.EnvFromSource{
+			SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: name}},
+		})
+	}
+
+	container.Env = inline
+	container.EnvFrom = envFrom
+	return configMap, secret
+}
+
+// referencesAny reports whether value contains $(NAME) for one of names
+func referencesAny(value string, names map[string]bool) bool {
+	for name := range names {
+		if strings.Contains(value, "$("+name+")") {
+			return true
+		}
+	}
+	return false
+}
+
+// podSpecOf returns the pod spec inside a built-in workload object
+func podSpecOf(object runtime.Object) (*corev1.PodSpec, error) {
+	switch o := object.(type) {
+	case *appsv1.Deployment:
+		return &o.Spec.Template.Spec, nil
+	case *appsv1.StatefulSet:
+		return &o.Spec.Template.Spec, nil
+	case *appsv1.DaemonSet:
+		return &o.Spec.Template.Spec, nil
+	case *appsv1.ReplicaSet:
+		return &o.Spec.Template.Spec, nil
+	case *batchv1.Job:
+		return &o.Spec.Template.Spec, nil
+	case *batchv1.CronJob:
+		return &o.Spec.JobTemplate.Spec.Template.Spec, nil
+	case *corev1.ReplicationController:
+		if o.Spec.Template == nil {
+			return nil, fmt.Errorf("replicationcontroller %s has no pod template", o.Name)
+		}
+		return &o.Spec.Template.Spec, nil
+	case *corev1.PodTemplate:
+		return &o.Template.Spec, nil
+	case *corev1.Pod:
+		return &o.Spec, nil
+	default:
+		return nil, fmt.Errorf("patching %s is not supported, only built-in workload kinds can be flattened", object.GetObjectKind().GroupVersionKind().Kind)
+	}
+}
+
+// toManifest converts the object to a map without status and server-side
+// metadata, with apiVersion and kind set
+func toManifest(object runtime.Object) (map[string]interface{}, error) {
+	if object.GetObjectKind().GroupVersionKind().Empty() {
+		gvks, _, err := manifestScheme.ObjectKinds(object)
+		if err != nil {
+			return nil, fmt.Errorf("failed to determine kind: %w", err)
+		}
+		object.GetObjectKind().SetGroupVersionKind(gvks[0])
+	}
+
+	manifest, err := runtime.DefaultUnstructuredConverter.ToUnstructured(object)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert manifest: %w", err)
+	}
+
+	delete(manifest, "status")
+	for _, field := range []string{"creationTimestamp", "resourceVersion", "uid", "generation", "managedFields", "selfLink"} {
+		unstructured.RemoveNestedField(manifest, "metadata", field)
+	}
+	unstructured.RemoveNestedField(manifest, "metadata", "annotations", corev1.LastAppliedConfigAnnotation)
+	if annotations, found, _ := unstructured.NestedMap(manifest, "metadata", "annotations"); found && len(annotations) == 0 {
+		unstructured.RemoveNestedField(manifest, "metadata", "annotations")
+	}
+	return manifest, nil
+}
@@ -0,0 +1,204 @@
+package formatter
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/whywaita/keex/pkg/extractor"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+func TestFormatK8sManifests(t *testing.T) {
+	deploy := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "prod", ResourceVersion: "42"},
+		Spec: appsv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name:  "app",
+							Image: "api:v1",
+							Env: []corev1.EnvVar{
+								{Name: "LOG_LEVEL", Value: "debug"},
+								{Name: "POD_IP", ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "status.podIP"}}},
+								{Name: "ADVERTISE", Value: "$(POD_IP):8080"},
+								{Name: "DB_PASSWORD", ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+									LocalObjectReference: corev1.LocalObjectReference{Name: "db"},
+									Key:                  "password",
+								}}},
+							},
+							EnvFrom: []corev1.EnvFromSource{
+								{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "external"}}},
+							},
+						},
+					},
+				},
+			},
+		},
+		Status: appsv1.DeploymentStatus{Replicas: 2},
+	}
+	workload := &extractor.Workload{Kind: "Deployment", Namespace: "prod", Name: "api", Object: deploy}
+	origin := extractor.Origin{Kind: "Deployment", Namespace: "prod", Name: "api", Container: "app"}
+
+	envVars := []extractor.EnvVar{
+		{Name: "# from secret: external", Source: extractor.SourceSecret, SecretRef: &extractor.SecretKeyRef{Name: "external", Key: "*"}, EnvFrom: true, Origin: origin},
+		{Name: "LOG_LEVEL", Value: "debug", Source: extractor.SourceDirect, Origin: origin},
+		{Name: "POD_IP", Value: "<fieldRef:status.podIP>", Source: extractor.SourceFieldRef, Origin: origin},
+		{Name: "ADVERTISE", Value: "<fieldRef:status.podIP>:8080", Source: extractor.SourceDirect, Origin: origin},
+		{Name: "DB_PASSWORD", Value: "hunter2", Source: extractor.SourceSecret, IsSecret: true, Origin: origin},
+	}
+
+	output, err := FormatK8sManifests(workload, "", envVars, "api-flat", "staging")
+	if err != nil {
+		t.Fatalf("FormatK8sManifests() error = %v", err)
+	}
+
+	documents := strings.Split(output, "\n---\n")
+	if len(documents) != 3 {
+		t.Fatalf("FormatK8sManifests() returned %d documents, want 3:\n%s", len(documents), output)
+	}
+
+	var configMap corev1.ConfigMap
+	if err := yaml.Unmarshal([]byte(documents[0]), &configMap); err != nil {
+		t.Fatal(err)
+	}
+	if configMap.Kind != "ConfigMap" || configMap.Name != "api-flat-env" || configMap.Namespace != "staging" {
+		t.Errorf("ConfigMap = %s %s/%s", configMap.Kind, configMap.Namespace, configMap.Name)
+	}
+	if len(configMap.Data) != 1 || configMap.Data["LOG_LEVEL"] != "debug" {
+		t.Errorf("ConfigMap data = %v, want only LOG_LEVEL", configMap.Data)
+	}
+
+	var secret corev1.Secret
+	if err := yaml.Unmarshal([]byte(documents[1]), &secret); err != nil {
+		t.Fatal(err)
+	}
+	if secret.Kind != "Secret" || secret.Name != "api-flat-env" || string(secret.Data["DB_PASSWORD"]) != "hunter2" {
+		t.Errorf("Secret = %s %s data %v", secret.Kind, secret.Name, secret.Data)
+	}
+	if !strings.Contains(documents[1], "DB_PASSWORD: aHVudGVyMg==") {
+		t.Errorf("Secret data is not base64 encoded:\n%s", documents[1])
+	}
+
+	if strings.Contains(documents[2], "\nstatus:") || strings.Contains(documents[2], "resourceVersion") {
+		t.Errorf("workload keeps server-side fields:\n%s", documents[2])
+	}
+	var patched appsv1.Deployment
+	if err := yaml.Unmarshal([]byte(documents[2]), &patched); err != nil {
+		t.Fatal(err)
+	}
+	if patched.APIVersion != "apps/v1" || patched.Kind != "Deployment" || patched.Namespace != "staging" {
+		t.Errorf("workload = %s %s in %s", patched.APIVersion, patched.Kind, patched.Namespace)
+	}
+
+	container := patched.Spec.Template.Spec.Containers[0]
+	var inline []string
+	for _, env := range container.Env {
+		inline = append(inline, env.Name)
+	}
+	if strings.Join(inline, ",") != "POD_IP,ADVERTISE" {
+		t.Errorf("inline env = %v, want POD_IP and ADVERTISE", inline)
+	}
+	if len(container.EnvFrom) != 3 ||
+		container.EnvFrom[0].SecretRef.Name != "external" ||
+		container.EnvFrom[1].ConfigMapRef.Name != "api-flat-env" ||
+		container.EnvFrom[2].SecretRef.Name != "api-flat-env" {
+		t.Errorf("envFrom = %+v", container.EnvFrom)
+	}
+
+	// The workload read from the manifest is left untouched
+	if len(deploy.Spec.Template.Spec.Containers[0].Env) != 4 {
+		t.Error("FormatK8sManifests() modified the input workload")
+	}
+}
+
+func TestFormatK8sManifestsSensitiveValues(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "api"},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{
+			Name: "app",
+			Env: []corev1.EnvVar{
+				{Name: "DB_PASS", ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "db"},
+					Key:                  "password",
+				}}},
+				{Name: "DATABASE_URL", Value: "postgres://u:$(DB_PASS)@db/app"},
+				{Name: "API_TOKEN", Value: "sk_live_abcdef"},
+				{Name: "LOG_LEVEL", Value: "debug"},
+			},
+		}}},
+	}
+	workload := &extractor.Workload{Kind: "Pod", Name: "api", Object: pod}
+	origin := extractor.Origin{Kind: "Pod", Name: "api", Container: "app"}
+
+	envVars := []extractor.EnvVar{
+		{Name: "DB_PASS", Value: "hunter2", Source: extractor.SourceSecret, IsSecret: true, Origin: origin},
+		// Expanded from a secret
+		{Name: "DATABASE_URL", Value: "postgres://u:hunter2@db/app", Source: extractor.SourceDirect, IsSecret: true, Origin: origin},
+		// Flagged by the detector
+		{Name: "API_TOKEN", Value: "sk_live_abcdef", Source: extractor.SourceDirect, IsSecret: true, Origin: origin},
+		{Name: "LOG_LEVEL", Value: "debug", Source: extractor.SourceDirect, Origin: origin},
+	}
+
+	output, err := FormatK8sManifests(workload, "", envVars, "", "")
+	if err != nil {
+		t.Fatalf("FormatK8sManifests() error = %v", err)
+	}
+	documents := strings.Split(output, "\n---\n")
+	if len(documents) != 3 {
+		t.Fatalf("FormatK8sManifests() returned %d documents, want 3:\n%s", len(documents), output)
+	}
+
+	var configMap corev1.ConfigMap
+	if err := yaml.Unmarshal([]byte(documents[0]), &configMap); err != nil {
+		t.Fatal(err)
+	}
+	if len(configMap.Data) != 1 || configMap.Data["LOG_LEVEL"] != "debug" {
+		t.Errorf("ConfigMap data = %v, want only LOG_LEVEL", configMap.Data)
+	}
+
+	var secret corev1.Secret
+	if err := yaml.Unmarshal([]byte(documents[1]), &secret); err != nil {
+		t.Fatal(err)
+	}
+	if string(secret.Data["DATABASE_URL"]) != "postgres://u:hunter2@db/app" || string(secret.Data["API_TOKEN"]) != "sk_live_abcdef" || len(secret.Data) != 3 {
+		t.Errorf("Secret data = %v, want DB_PASS, DATABASE_URL and API_TOKEN", secret.Data)
+	}
+
+	// Masked values would be deployed as they are
+	envVars[0].Value, envVars[0].Redacted = "***REDACTED***", true
+	if _, err := FormatK8sManifests(workload, "", envVars, "", ""); err == nil || !strings.Contains(err.Error(), "DB_PASS") {
+		t.Errorf("FormatK8sManifests() error = %v, want redacted DB_PASS rejected", err)
+	}
+}
+
+func TestFormatK8sManifestsWithoutEnv(t *testing.T) {
+	pod := &corev1.Pod{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+		ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "prod"},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "app:1.0"}}},
+	}
+	workload := &extractor.Workload{Kind: "Pod", Namespace: "prod", Name: "api", Object: pod}
+
+	output, err := FormatK8sManifests(workload, "", nil, "", "")
+	if err != nil {
+		t.Fatalf("FormatK8sManifests() error = %v", err)
+	}
+	var printed corev1.Pod
+	if err := yaml.Unmarshal([]byte(output), &printed); err != nil {
+		t.Fatalf("FormatK8sManifests() = %s, want only the workload: %v", output, err)
+	}
+	if strings.Contains(output, "---") || printed.Name != "api" || printed.Spec.Containers[0].Image != "app:1.0" {
+		t.Errorf("FormatK8sManifests() = %s, want the workload unchanged", output)
+	}
+}
+
+func TestFormatK8sManifestsUnsupported(t *testing.T) {
+	workload := &extractor.Workload{Kind: "Rollout", Name: "api"}
+	if _, err := FormatK8sManifests(workload, "", nil, "", ""); err == nil {
+		t.Error("FormatK8sManifests() expected error without a manifest")
+	}
+}
@@ -0,0 +1,322 @@
+package formatter
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/whywaita/keex/pkg/extractor"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Scope tells a CLI how much of the manifest a format needs
+type Scope int
+
+const (
+	// ScopeEnv formats only need environment variables and accept any number
+	// of workloads
+	ScopeEnv Scope = iota
+	// ScopeContainer formats need a single workload and one of its containers
+	ScopeContainer
+	// ScopeWorkload formats need a single workload with all its containers
+	ScopeWorkload
+)
+
+// Option is a setting a format may support
+type Option int
+
+const (
+	// OptionExport prefixes shell assignments with export
+	OptionExport Option = 1 << iota
+	// OptionMounts bind mounts materialized Secret and ConfigMap volumes
+	OptionMounts
+	// OptionOutputObject names the generated objects and sets their namespace
+	OptionOutputObject
+	// OptionDialect selects the syntax of KEY=value files
+	OptionDialect
+	// OptionUnset writes commands that remove the variables instead
+	OptionUnset
+)
+
+// Input is what a formatter renders. Workload and Container are set
+// according to the scope of the format. EnvVars are already redacted, values
+// are written as they are.
+type Input struct {
+	EnvVars   []extractor.EnvVar
+	Workload  *extractor.Workload
+	Container *corev1.Container
+	// Mounts holds the materialized volumes keyed by container name
+	Mounts map[string][]Mount
+	// ManifestPath is the manifest file the input was read from, empty for
+	// stdin or a cluster
+	ManifestPath string
+}
+
+// Options holds the settings given on the command line
+type Options struct {
+	Export bool
+	// Dialect is the syntax of KEY=value files, DialectCompose by default
+	Dialect Dialect
+	Unset   bool
+	// OutputName and OutputNamespace apply to generated objects
+	OutputName      string
+	OutputNamespace string
+}
+
+// Formatter renders environment variables in an output format
+type Formatter interface {
+	Format(in Input, opts Options) (string, error)
+}
+
+// FormatterFunc adapts a function to the Formatter interface
+type FormatterFunc func(in Input, opts Options) (string, error)
+
+// Format calls f(in, opts)
+func (f FormatterFunc) Format(in Input, opts Options) (string, error) {
+	return f(in, opts)
+}
+
+// Format describes a registered output format
+type Format struct {
+	Name        string
+	Aliases     []string
+	Description string
+	Scope       Scope
+	Options     Option
+	Formatter   Formatter
+}
+
+// Supports reports whether the format accepts the option
+func (f Format) Supports(option Option) bool {
+	return f.Options&option != 0
+}
+
+var registry []Format
+
+// Register adds a format. It panics if the name or an alias is already taken.
+func Register(format Format) {
+	for _, name := range append([]string{format.Name}, format.Aliases...) {
+		if _, ok := Lookup(name); ok {
+			panic(fmt.Sprintf("formatter: format %s registered twice", name))
+		}
+	}
+	registry = append(registry, format)
+}
+
+// Lookup returns the format registered under name or one of its aliases
+func Lookup(name string) (Format, bool) {
+	for _, format := range registry {
+		if format.Name == name {
+			return format, true
+		}
+		for _, alias := range format.Aliases {
+			if alias == name {
+				return format, true
+			}
+		}
+	}
+	return Format{}, false
+}
+
+// Formats returns the registered formats sorted by name
+func Formats() []Format {
+	formats := append([]Format(nil), registry...)
+	sort.Slice(formats, func(i, j int) bool {
+		return formats[i].Name < formats[j].Name
+	})
+	return formats
+}
+
+// Names returns the names and aliases of every registered format
+func Names() []string {
+	var names []string
+	for _, format := range Formats() {
+		names = append(names, format.Name)
+		names = append(names, format.Aliases...)
+	}
+	return names
+}
+
+// Help lists the registered formats with their descriptions, one per line
+func Help() string {
+	formats := Formats()
+
+	labels := make([]string, len(formats))
+	width := 0
+	for i, format := range formats {
+		labels[i] = format.Name
+		if len(format.Aliases) > 0 {
+			labels[i] += " (" + strings.Join(format.Aliases, ", ") + ")"
+		}
+		width = max(width, len(labels[i]))
+	}
+
+	lines := make([]string, len(formats))
+	for i, format := range formats {
+		lines[i] = fmt.Sprintf("  %-*s  %s", width, labels[i], format.Description)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func init() {
+	Register(Format{
+		Name:        "docker",
+		Description: "-e flags for docker run",
+		Options:     OptionMounts,
+		Formatter: FormatterFunc(func(in Input, opts Options) (string, error) {
+			output := FormatDocker(in.EnvVars)
+			if in.Container != nil {
+				if volumes := FormatMounts(in.Mounts[in.Container.Name]); volumes != "" {
+					output += " " + volumes
+				}
+			}
+			return output, nil
+		}),
+	})
+	Register(Format{
+		Name:        "env",
+		Aliases:     []string{"shell"},
+		Description: "KEY='value' POSIX shell assignments",
+		Options:     OptionExport | OptionUnset,
+		Formatter: FormatterFunc(func(in Input, opts Options) (string, error) {
+			if opts.Unset {
+				return FormatShellUnset(in.EnvVars), nil
+			}
+			return FormatShell(in.EnvVars, opts.Export), nil
+		}),
+	})
+	Register(Format{
+		Name:        "direnv",
+		Description: ".envrc that exports the variables and watches the manifest",
+		Formatter: FormatterFunc(func(in Input, opts Options) (string, error) {
+			return FormatDirenv(in.EnvVars, in.ManifestPath), nil
+		}),
+	})
+	Register(Format{
+		Name:        "systemd",
+		Description: "file for the systemd EnvironmentFile= directive",
+		Formatter: FormatterFunc(func(in Input, opts Options) (string, error) {
+			return FormatSystemd(in.EnvVars), nil
+		}),
+	})
+	Register(Format{
+		Name:        "systemd-dropin",
+		Description: "systemd unit drop-in with Environment= lines",
+		Formatter: FormatterFunc(func(in Input, opts Options) (string, error) {
+			return FormatSystemdDropin(in.EnvVars), nil
+		}),
+	})
+	Register(Format{
+		Name:        "fish",
+		Description: "fish set -gx commands",
+		Options:     OptionUnset,
+		Formatter: FormatterFunc(func(in Input, opts Options) (string, error) {
+			return FormatFish(in.EnvVars, opts.Unset), nil
+		}),
+	})
+	Register(Format{
+		Name:        "powershell",
+		Aliases:     []string{"pwsh"},
+		Description: "PowerShell $env: assignments",
+		Options:     OptionUnset,
+		Formatter: FormatterFunc(func(in Input, opts Options) (string, error) {
+			return FormatPowerShell(in.EnvVars, opts.Unset), nil
+		}),
+	})
+	Register(Format{
+		Name:        "nushell",
+		Aliases:     []string{"nu"},
+		Description: "nushell load-env record",
+		Options:     OptionUnset,
+		Formatter: FormatterFunc(func(in Input, opts Options) (string, error) {
+			return FormatNushell(in.EnvVars, opts.Unset), nil
+		}),
+	})
+	Register(Format{
+		Name:        "cmd",
+		Description: `cmd.exe batch set "K=V" commands`,
+		Options:     OptionUnset,
+		Formatter: FormatterFunc(func(in Input, opts Options) (string, error) {
+			return FormatCmd(in.EnvVars, opts.Unset)
+		}),
+	})
+	Register(Format{
+		Name:        "csh",
+		Aliases:     []string{"tcsh"},
+		Description: "csh and tcsh setenv commands",
+		Options:     OptionUnset,
+		Formatter: FormatterFunc(func(in Input, opts Options) (string, error) {
+			return FormatCsh(in.EnvVars, opts.Unset), nil
+		}),
+	})
+	Register(Format{
+		Name:        "dotenv",
+		Description: ".env file quoted for the --dialect consumer",
+		Options:     OptionDialect,
+		Formatter: FormatterFunc(func(in Input, opts Options) (string, error) {
+			dialect := opts.Dialect
+			if dialect == "" {
+				dialect = DialectCompose
+			}
+			return FormatDotenv(in.EnvVars, dialect)
+		}),
+	})
+	Register(Format{
+		Name:        "compose",
+		Description: "environment section of a compose service",
+		Formatter: FormatterFunc(func(in Input, opts Options) (string, error) {
+			return FormatCompose(in.EnvVars), nil
+		}),
+	})
+	Register(Format{
+		Name:        "json",
+		Description: "versioned json document for scripts",
+		Formatter: FormatterFunc(func(in Input, opts Options) (string, error) {
+			return FormatJSON(in.EnvVars)
+		}),
+	})
+	Register(Format{
+		Name:        "yaml",
+		Description: "versioned yaml document for scripts",
+		Formatter: FormatterFunc(func(in Input, opts Options) (string, error) {
+			return FormatYAML(in.EnvVars)
+		}),
+	})
+	Register(Format{
+		Name:        "k8s-manifests",
+		Description: "ConfigMap, Secret and the workload patched to load them with envFrom",
+		Scope:       ScopeWorkload,
+		Options:     OptionOutputObject,
+		Formatter: FormatterFunc(func(in Input, opts Options) (string, error) {
+			containerName := ""
+			if in.Container != nil {
+				containerName = in.Container.Name
+			}
+			return FormatK8sManifests(in.Workload, containerName, in.EnvVars, opts.OutputName, opts.OutputNamespace)
+		}),
+	})
+	for _, engine := range []string{"docker", "podman"} {
+		Register(Format{
+			Name:        engine + "-run",
+			Description: fmt.Sprintf("complete %s run command for one container", engine),
+			Scope:       ScopeContainer,
+			Options:     OptionMounts,
+			Formatter: FormatterFunc(func(in Input, opts Options) (string, error) {
+				return FormatDockerRun(engine, in.Workload.Pod, in.Container, in.EnvVars, in.Mounts[in.Container.Name]), nil
+			}),
+		})
+	}
+	Register(Format{
+		Name:        "compose-service",
+		Description: "compose file with one service per container",
+		Scope:       ScopeWorkload,
+		Options:     OptionMounts,
+		Formatter: FormatterFunc(func(in Input, opts Options) (string, error) {
+			containerName := ""
+			if in.Container != nil {
+				containerName = in.Container.Name
+			}
+			return FormatComposeService(in.Workload.Pod, containerName, in.EnvVars, in.Mounts)
+		}),
+	})
+}
@@ -0,0 +1,53 @@
+package formatter
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLookup(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+		ok       bool
+	}{
+		{name: "docker", expected: "docker", ok: true},
+		{name: "env", expected: "env", ok: true},
+		{name: "shell", expected: "env", ok: true},
+		{name: "compose-service", expected: "compose-service", ok: true},
+		{name: "xml", ok: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			format, ok := Lookup(tt.name)
+			if ok != tt.ok {
+				t.Fatalf("Lookup(%q) ok = %v, want %v", tt.name, ok, tt.ok)
+			}
+			if format.Name != tt.expected {
+				t.Errorf("Lookup(%q) = %s, want %s", tt.name, format.Name, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRegisterDuplicate(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Register() expected panic for a taken alias")
+		}
+	}()
+	Register(Format{Name: "sh", Aliases: []string{"shell"}})
+}
+
+func TestHelp(t *testing.T) {
+	help := Help()
+	for _, format := range Formats() {
+		if !strings.Contains(help, format.Name) {
+			t.Errorf("Help() does not list %s:\n%s", format.Name, help)
+		}
+	}
+	if !strings.Contains(help, "env (shell)") {
+		t.Errorf("Help() does not list aliases:\n%s", help)
+	}
+}
@@ -0,0 +1,167 @@
+package formatter
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/whywaita/keex/pkg/extractor"
+)
+
+// FormatShellUnset writes POSIX unset commands that undo FormatShell
+func FormatShellUnset(envVars []extractor.EnvVar) string {
+	return formatNames(envVars, func(name string) string {
+		return "unset " + name
+	})
+}
+
+// FormatFish writes fish set -gx commands, or set -e commands to undo them
+func FormatFish(envVars []extractor.EnvVar, unset bool) string {
+	if unset {
+		return formatNames(envVars, func(name string) string {
+			return "set -e " + name
+		})
+	}
+
+	var parts []string
+	for _, env := range assignable(envVars) {
+		// Only \ and ' are special in fish single quotes, newlines are kept
+		value := strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(env.Value)
+		parts = append(parts, fmt.Sprintf(`set -gx %s '%s'`, env.Name, value))
+	}
+	return strings.Join(parts, "\n")
+}
+
+// powerShellIdentifier matches names that need no braces after $env:
+var powerShellIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// FormatPowerShell writes $env: assignments, or Remove-Item commands to undo
+// them
+func FormatPowerShell(envVars []extractor.EnvVar, unset bool) string {
+	if unset {
+		return formatNames(envVars, func(name string) string {
+			return fmt.Sprintf("Remove-Item -Path 'Env:%s' -ErrorAction SilentlyContinue", name)
+		})
+	}
+
+	var parts []string
+	for _, env := range assignable(envVars) {
+		variable := "$env:" + env.Name
+		if !powerShellIdentifier.MatchString(env.Name) {
+			variable = "${env:" + env.Name + "}"
+		}
+		// Single-quoted strings are literal, quotes are doubled. PowerShell
+		// also ends them at typographic single quotes.
+		value := env.Value
+		for _, quote := range []string{`'`, "‘", "’", "‚", "‛"} {
+			value = strings.ReplaceAll(value, quote, quote+quote)
+		}
+		parts = append(parts, fmt.Sprintf(`%s = '%s'`, variable, value))
+	}
+	return strings.Join(parts, "\n")
+}
+
+// FormatNushell writes a load-env record, or hide-env commands to undo it
+func FormatNushell(envVars []extractor.EnvVar, unset bool) string {
+	if unset {
+		return formatNames(envVars, func(name string) string {
+			return "hide-env --ignore-errors " + quoteNushell(name)
+		})
+	}
+
+	parts := []string{"load-env {"}
+	for _, env := range assignable(envVars) {
+		parts = append(parts, fmt.Sprintf("    %s: %s", quoteNushell(env.Name), quoteNushell(env.Value)))
+	}
+	parts = append(parts, "}")
+	return strings.Join(parts, "\n")
+}
+
+// quoteNushell returns s as a double-quoted nushell string, which is not
+// interpolated
+func quoteNushell(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`).Replace(s) + `"`
+}
+
+// FormatCmd writes set "K=V" commands for cmd.exe batch files, or set "K="
+// to undo them. cmd cannot assign values with line breaks.
+func FormatCmd(envVars []extractor.EnvVar, unset bool) (string, error) {
+	if unset {
+		return formatNames(envVars, func(name string) string {
+			return fmt.Sprintf(`set "%s="`, name)
+		}), nil
+	}
+
+	var parts []string
+	for _, env := range assignable(envVars) {
+		if strings.ContainsAny(env.Value, "\r\n") {
+			return "", fmt.Errorf("%s: cmd cannot hold values with line breaks", env.Name)
+		}
+		parts = append(parts, fmt.Sprintf(`set "%s=%s"`, env.Name, quoteCmd(env.Value)))
+	}
+	return strings.Join(parts, "\n"), nil
+}
+
+// quoteCmd escapes value for the inside of set "K=V" in a batch file. set
+// strips everything after the last quote, so quotes in the value are kept,
+// but they switch cmd in and out of quoted mode; special characters outside
+// quoted mode are escaped with ^. % is doubled to prevent expansion; delayed
+// expansion is assumed to be off.
+func quoteCmd(value string) string {
+	var b strings.Builder
+	quoted := true
+	for _, r := range value {
+		switch {
+		case r == '%':
+			b.WriteString("%%")
+		case r == '"':
+			quoted = !quoted
+			b.WriteRune(r)
+		case !quoted && strings.ContainsRune(`^&|<>()`, r):
+			b.WriteRune('^')
+			b.WriteRune(r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// FormatCsh writes csh and tcsh setenv commands, or unsetenv commands to undo
+// them
+func FormatCsh(envVars []extractor.EnvVar, unset bool) string {
+	if unset {
+		return formatNames(envVars, func(name string) string {
+			return "unsetenv " + name
+		})
+	}
+
+	var parts []string
+	for _, env := range assignable(envVars) {
+		// csh single quotes still see history substitution and need a
+		// backslash before newlines
+		value := strings.NewReplacer(`'`, `'\''`, "!", `\!`, "\n", "\\\n").Replace(env.Value)
+		parts = append(parts, fmt.Sprintf(`setenv %s '%s'`, env.Name, value))
+	}
+	return strings.Join(parts, "\n")
+}
+
+// assignable returns envVars without comment entries
+func assignable(envVars []extractor.EnvVar) []extractor.EnvVar {
+	var filtered []extractor.EnvVar
+	for _, env := range envVars {
+		if !strings.HasPrefix(env.Name, "#") {
+			filtered = append(filtered, env)
+		}
+	}
+	return filtered
+}
+
+// formatNames writes one line per variable name
+func formatNames(envVars []extractor.EnvVar, line func(name string) string) string {
+	var parts []string
+	for _, env := range assignable(envVars) {
+		parts = append(parts, line(env.Name))
+	}
+	return strings.Join(parts, "\n")
+}
@@ -0,0 +1,143 @@
+package formatter
+
+import (
+	"testing"
+
+	"github.com/whywaita/keex/pkg/extractor"
+)
+
+func TestFormatShells(t *testing.T) {
+	envVars := []extractor.EnvVar{
+		{Name: "# from secret: extra", Source: extractor.SourceSecret},
+		{Name: "QUOTES", Value: `it's "quoted" \ $HOME`, Source: extractor.SourceDirect},
+		{Name: "MULTI", Value: "line1\nline2!", Source: extractor.SourceDirect},
+	}
+
+	tests := []struct {
+		name     string
+		format   func([]extractor.EnvVar, bool) string
+		unset    bool
+		expected string
+	}{
+		{
+			name:   "fish",
+			format: FormatFish,
+			expected: `set -gx QUOTES 'it\'s "quoted" \\ $HOME'
+set -gx MULTI 'line1
+line2!'`,
+		},
+		{
+			name:     "fish unset",
+			format:   FormatFish,
+			unset:    true,
+			expected: "set -e QUOTES\nset -e MULTI",
+		},
+		{
+			name:   "powershell",
+			format: FormatPowerShell,
+			expected: `$env:QUOTES = 'it''s "quoted" \ $HOME'
+$env:MULTI = 'line1
+line2!'`,
+		},
+		{
+			name:     "powershell unset",
+			format:   FormatPowerShell,
+			unset:    true,
+			expected: "Remove-Item -Path 'Env:QUOTES' -ErrorAction SilentlyContinue\nRemove-Item -Path 'Env:MULTI' -ErrorAction SilentlyContinue",
+		},
+		{
+			name:   "nushell",
+			format: FormatNushell,
+			expected: `load-env {
+    "QUOTES": "it's \"quoted\" \\ $HOME"
+    "MULTI": "line1\nline2!"
+}`,
+		},
+		{
+			name:     "nushell unset",
+			format:   FormatNushell,
+			unset:    true,
+			expected: "hide-env --ignore-errors \"QUOTES\"\nhide-env --ignore-errors \"MULTI\"",
+		},
+		{
+			name:   "csh",
+			format: FormatCsh,
+			expected: `setenv QUOTES 'it'\''s "quoted" \ $HOME'
+setenv MULTI 'line1\
+line2\!'`,
+		},
+		{
+			name:     "csh unset",
+			format:   FormatCsh,
+			unset:    true,
+			expected: "unsetenv QUOTES\nunsetenv MULTI",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := tt.format(envVars, tt.unset)
+			if result != tt.expected {
+				t.Errorf("got\n%s\nwant\n%s", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestFormatPowerShellSpecialNames(t *testing.T) {
+	envVars := []extractor.EnvVar{
+		{Name: "app.mode", Value: "don’t", Source: extractor.SourceDirect},
+	}
+	expected := "${env:app.mode} = 'don’’t'"
+	if result := FormatPowerShell(envVars, false); result != expected {
+		t.Errorf("FormatPowerShell() = %q, want %q", result, expected)
+	}
+}
+
+func TestFormatCmd(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		expected string
+	}{
+		{name: "plain", value: "value", expected: `set "K=value"`},
+		{name: "percent", value: "100%", expected: `set "K=100%%"`},
+		{name: "specials inside quotes", value: "a&b|c", expected: `set "K=a&b|c"`},
+		{name: "specials after a quote", value: `5" & <more>`, expected: `set "K=5" ^& ^<more^>"`},
+		{name: "specials after quote pairs", value: `say "hi" & bye`, expected: `set "K=say "hi" & bye"`},
+		{name: "specials between quotes", value: `a"&"b`, expected: `set "K=a"^&"b"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := FormatCmd([]extractor.EnvVar{{Name: "K", Value: tt.value}}, false)
+			if err != nil {
+				t.Fatalf("FormatCmd() error = %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("FormatCmd() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+
+	if _, err := FormatCmd([]extractor.EnvVar{{Name: "K", Value: "a\nb"}}, false); err == nil {
+		t.Error("FormatCmd() expected error for values with line breaks")
+	}
+
+	unset, err := FormatCmd([]extractor.EnvVar{{Name: "K", Value: "a\nb"}}, true)
+	if err != nil || unset != `set "K="` {
+		t.Errorf("FormatCmd() unset = %q, %v", unset, err)
+	}
+}
+
+func TestFormatShellUnsetRoundTrip(t *testing.T) {
+	requireShell(t)
+	envVars := roundTripEnvVars()
+	values, err := runShell(FormatShell(envVars, true) + "\n" + FormatShellUnset(envVars) + "\nprintf '%s\\0' \"${PLAIN-unset}\" \"${PEM-unset}\"")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 2 || values[0] != "unset" || values[1] != "unset" {
+		t.Errorf("variables are still set after unset: %q", values)
+	}
+}
@@ -0,0 +1,110 @@
+package formatter
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/whywaita/keex/pkg/extractor"
+	"sigs.k8s.io/yaml"
+)
+
+// SchemaVersion identifies the layout of the json and yaml output. It only
+// changes when fields are removed or change meaning.
+const SchemaVersion = "keex/v1"
+
+// Document is the json and yaml output
+type Document struct {
+	SchemaVersion string        `json:"schemaVersion"`
+	EnvVars       []EnvVarEntry `json:"envVars"`
+}
+
+// EnvVarEntry is an environment variable in the json and yaml output
+type EnvVarEntry struct {
+	Name   string    `json:"name"`
+	Value  string    `json:"value"`
+	Source string    `json:"source"`
+	Secret bool      `json:"secret"`
+	Ref    *RefEntry `json:"ref,omitempty"`
+	Prefix string    `json:"prefix,omitempty"`
+	// EnvFrom is set when the variable was imported with envFrom
+	EnvFrom bool `json:"envFrom,omitempty"`
+	// Redacted is set when value was masked
+	Redacted bool        `json:"redacted,omitempty"`
+	Origin   OriginEntry `json:"origin"`
+}
+
+// RefEntry is the Secret or ConfigMap key a value was read from
+type RefEntry struct {
+	Name     string `json:"name"`
+	Key      string `json:"key"`
+	Optional bool   `json:"optional,omitempty"`
+}
+
+// OriginEntry is the resource and container a variable belongs to
+type OriginEntry struct {
+	Kind          string `json:"kind,omitempty"`
+	Namespace     string `json:"namespace,omitempty"`
+	Name          string `json:"name,omitempty"`
+	Container     string `json:"container,omitempty"`
+	InitContainer bool   `json:"initContainer,omitempty"`
+}
+
+// NewDocument converts envVars to the json and yaml output
+func NewDocument(envVars []extractor.EnvVar) Document {
+	doc := Document{
+		SchemaVersion: SchemaVersion,
+		EnvVars:       make([]EnvVarEntry, 0, len(envVars)),
+	}
+
+	for _, env := range envVars {
+		// Skip comment entries
+		if strings.HasPrefix(env.Name, "#") {
+			continue
+		}
+
+		entry := EnvVarEntry{
+			Name:     env.Name,
+			Value:    env.Value,
+			Source:   env.Source.String(),
+			Secret:   env.IsSecret,
+			Prefix:   env.Prefix,
+			EnvFrom:  env.EnvFrom,
+			Redacted: env.Redacted,
+			Origin: OriginEntry{
+				Kind:          env.Origin.Kind,
+				Namespace:     env.Origin.Namespace,
+				Name:          env.Origin.Name,
+				Container:     env.Origin.Container,
+				InitContainer: env.Origin.InitContainer,
+			},
+		}
+		switch {
+		case env.SecretRef != nil:
+			entry.Ref = &RefEntry{Name: env.SecretRef.Name, Key: env.SecretRef.Key, Optional: env.SecretRef.Optional}
+		case env.ConfigRef != nil:
+			entry.Ref = &RefEntry{Name: env.ConfigRef.Name, Key: env.ConfigRef.Key, Optional: env.ConfigRef.Optional}
+		}
+		doc.EnvVars = append(doc.EnvVars, entry)
+	}
+
+	return doc
+}
+
+// FormatJSON renders envVars as an indented json Document
+func FormatJSON(envVars []extractor.EnvVar) (string, error) {
+	data, err := json.MarshalIndent(NewDocument(envVars), "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode json: %w", err)
+	}
+	return string(data), nil
+}
+
+// FormatYAML renders envVars as a yaml Document
+func FormatYAML(envVars []extractor.EnvVar) (string, error) {
+	data, err := yaml.Marshal(NewDocument(envVars))
+	if err != nil {
+		return "", fmt.Errorf("failed to encode yaml: %w", err)
+	}
+	return strings.TrimSuffix(string(data), "\n"), nil
+}
@@ -0,0 +1,183 @@
+package formatter
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/whywaita/keex/pkg/extractor"
+	"github.com/whywaita/keex/pkg/resolver"
+	"sigs.k8s.io/yaml"
+)
+
+func TestFormatStructured(t *testing.T) {
+	envVars := []extractor.EnvVar{
+		{
+			Name:   "LOG_LEVEL",
+			Value:  "debug",
+			Source: extractor.SourceDirect,
+			Origin: extractor.Origin{Kind: "Deployment", Namespace: "prod", Name: "api", Container: "app"},
+		},
+		{
+			Name:      "DB_PASSWORD",
+			Value:     "***REDACTED***",
+			Source:    extractor.SourceSecret,
+			IsSecret:  true,
+			Redacted:  true,
+			SecretRef: &extractor.SecretKeyRef{Name: "db", Key: "password"},
+			Origin:    extractor.Origin{Kind: "Deployment", Namespace: "prod", Name: "api", Container: "app"},
+		},
+		{
+			Name:      "APP_MODE",
+			Value:     "blue",
+			Source:    extractor.SourceConfigMap,
+			ConfigRef: &extractor.ConfigMapKeyRef{Name: "settings", Key: "MODE", Optional: true},
+			Prefix:    "APP_",
+			EnvFrom:   true,
+			Origin:    extractor.Origin{Kind: "Deployment", Namespace: "prod", Name: "api", Container: "init", InitContainer: true},
+		},
+		{
+			Name:      "# from secret: extra",
+			Source:    extractor.SourceSecret,
+			SecretRef: &extractor.SecretKeyRef{Name: "extra", Key: "*"},
+			EnvFrom:   true,
+		},
+	}
+
+	expected := Document{
+		SchemaVersion: SchemaVersion,
+		EnvVars: []EnvVarEntry{
+			{
+				Name:   "LOG_LEVEL",
+				Value:  "debug",
+				Source: "direct",
+				Origin: OriginEntry{Kind: "Deployment", Namespace: "prod", Name: "api", Container: "app"},
+			},
+			{
+				Name:     "DB_PASSWORD",
+				Value:    "***REDACTED***",
+				Source:   "secret",
+				Secret:   true,
+				Redacted: true,
+				Ref:      &RefEntry{Name: "db", Key: "password"},
+				Origin:   OriginEntry{Kind: "Deployment", Namespace: "prod", Name: "api", Container: "app"},
+			},
+			{
+				Name:    "APP_MODE",
+				Value:   "blue",
+				Source:  "configMap",
+				Ref:     &RefEntry{Name: "settings", Key: "MODE", Optional: true},
+				Prefix:  "APP_",
+				EnvFrom: true,
+				Origin:  OriginEntry{Kind: "Deployment", Namespace: "prod", Name: "api", Container: "init", InitContainer: true},
+			},
+		},
+	}
+	want, err := json.Marshal(expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jsonOutput, err := FormatJSON(envVars)
+	if err != nil {
+		t.Fatalf("FormatJSON() error = %v", err)
+	}
+	var fromJSON Document
+	if err := json.Unmarshal([]byte(jsonOutput), &fromJSON); err != nil {
+		t.Fatalf("FormatJSON() produced invalid json: %v", err)
+	}
+	if got, _ := json.Marshal(fromJSON); string(got) != string(want) {
+		t.Errorf("FormatJSON() = %s, want %s", got, want)
+	}
+
+	yamlOutput, err := FormatYAML(envVars)
+	if err != nil {
+		t.Fatalf("FormatYAML() error = %v", err)
+	}
+	var fromYAML Document
+	if err := yaml.Unmarshal([]byte(yamlOutput), &fromYAML); err != nil {
+		t.Fatalf("FormatYAML() produced invalid yaml: %v", err)
+	}
+	if got, _ := json.Marshal(fromYAML); string(got) != string(want) {
+		t.Errorf("FormatYAML() = %s, want %s", got, want)
+	}
+}
+
+func TestFormatJSONFromManifest(t *testing.T) {
+	manifest := `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: settings
+  namespace: prod
+data:
+  MODE: blue
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: db
+  namespace: prod
+stringData:
+  PASSWORD: s3cr3t
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: api
+  namespace: prod
+spec:
+  template:
+    spec:
+      containers:
+      - name: app
+        envFrom:
+        - prefix: APP_
+          configMapRef:
+            name: settings
+            optional: true
+        - prefix: DB_
+          secretRef:
+            name: db`
+
+	result, err := extractor.New().ExtractAll(strings.NewReader(manifest), extractor.Options{})
+	if err != nil {
+		t.Fatalf("ExtractAll() error = %v", err)
+	}
+	res := resolver.NewOffline("")
+	res.AddObjects(result.Secrets, result.ConfigMaps)
+	envVars, err := res.ResolveAll(result.EnvVars)
+	if err != nil {
+		t.Fatalf("ResolveAll() error = %v", err)
+	}
+
+	output, err := FormatJSON(envVars)
+	if err != nil {
+		t.Fatalf("FormatJSON() error = %v", err)
+	}
+	var doc Document
+	if err := json.Unmarshal([]byte(output), &doc); err != nil {
+		t.Fatalf("FormatJSON() produced invalid json: %v", err)
+	}
+
+	origin := OriginEntry{Kind: "Deployment", Namespace: "prod", Name: "api", Container: "app"}
+	expected := []EnvVarEntry{
+		{Name: "APP_MODE", Value: "blue", Source: "configMap", Ref: &RefEntry{Name: "settings", Key: "MODE", Optional: true}, Prefix: "APP_", EnvFrom: true, Origin: origin},
+		{Name: "DB_PASSWORD", Value: "s3cr3t", Source: "secret", Secret: true, Ref: &RefEntry{Name: "db", Key: "PASSWORD"}, Prefix: "DB_", EnvFrom: true, Origin: origin},
+	}
+	got, _ := json.Marshal(doc.EnvVars)
+	want, _ := json.Marshal(expected)
+	if string(got) != string(want) {
+		t.Errorf("FormatJSON() envVars = %s, want %s", got, want)
+	}
+}
+
+func TestFormatJSONEmpty(t *testing.T) {
+	output, err := FormatJSON(nil)
+	if err != nil {
+		t.Fatalf("FormatJSON() error = %v", err)
+	}
+	expected := "{\n  \"schemaVersion\": \"keex/v1\",\n  \"envVars\": []\n}"
+	if output != expected {
+		t.Errorf("FormatJSON() = %q, want %q", output, expected)
+	}
+}
@@ -0,0 +1,278 @@
+package procenv
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/whywaita/keex/pkg/differ"
+	"github.com/whywaita/keex/pkg/extractor"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// Method selects how the environment of a container is read
+type Method string
+
+const (
+	// MethodAuto reads /proc/1/environ and falls back to env when that fails
+	MethodAuto Method = "auto"
+	// MethodEnviron reads /proc/1/environ, the environment of the container's
+	// main process, including what an entrypoint wrapper such as Vault agent's
+	// added before starting it
+	MethodEnviron Method = "environ"
+	// MethodEnv runs env, which prints the environment the container runtime
+	// gives to new processes in the container
+	MethodEnv Method = "env"
+)
+
+// Methods lists the supported methods
+var Methods = []Method{MethodAuto, MethodEnviron, MethodEnv}
+
+// ParseMethod returns the method with the given name
+func ParseMethod(name string) (Method, error) {
+	for _, method := range Methods {
+		if string(method) == name {
+			return method, nil
+		}
+	}
+	return "", fmt.Errorf("invalid process method: %s (must be one of %s)", name, strings.Join(MethodNames(), ", "))
+}
+
+// MethodNames returns the names of the supported methods
+func MethodNames() []string {
+	names := make([]string, len(Methods))
+	for i, method := range Methods {
+		names[i] = string(method)
+	}
+	return names
+}
+
+// DefaultContainerAnnotation names the container kubectl exec and logs use
+// when none is given
+const DefaultContainerAnnotation = "kubectl.kubernetes.io/default-container"
+
+// DefaultContainer returns the container kubectl would pick in the pod: the
+// one named by DefaultContainerAnnotation, or the first one
+func DefaultContainer(pod *corev1.Pod) string {
+	if name := pod.Annotations[DefaultContainerAnnotation]; name != "" {
+		return name
+	}
+	if len(pod.Spec.Containers) == 0 {
+		return ""
+	}
+	return pod.Spec.Containers[0].Name
+}
+
+// Reader reads the environment of running containers through the exec
+// subresource
+type Reader struct {
+	client rest.Interface
+	// newExecutor opens an exec stream to the given URL
+	newExecutor func(url *url.URL) (remotecommand.Executor, error)
+}
+
+// NewReader returns a Reader that executes commands with client, the core/v1
+// REST client, over WebSocket with a fallback to SPDY like kubectl exec
+func NewReader(config *rest.Config, client rest.Interface) *Reader {
+	return &Reader{
+		client: client,
+		newExecutor: func(url *url.URL) (remotecommand.Executor, error) {
+			websocket, err := remotecommand.NewWebSocketExecutor(config, "GET", url.String())
+			if err != nil {
+				return nil, fmt.Errorf("failed to create websocket executor: %w", err)
+			}
+			spdy, err := remotecommand.NewSPDYExecutor(config, "POST", url)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create spdy executor: %w", err)
+			}
+			return remotecommand.NewFallbackExecutor(websocket, spdy, func(err error) bool {
+				return httpstream.IsUpgradeFailure(err) || httpstream.IsHTTPSProxyError(err)
+			})
+		},
+	}
+}
+
+// Read returns the environment of the container in the running pod as direct
+// env vars whose origin is the pod and container
+func (r *Reader) Read(ctx context.Context, pod *corev1.Pod, container string, method Method) ([]extractor.EnvVar, error) {
+	if pod.Status.Phase != corev1.PodRunning {
+		return nil, fmt.Errorf("pod %s is %s, not Running", pod.Name, pod.Status.Phase)
+	}
+
+	var envVars []extractor.EnvVar
+	switch method {
+	case MethodEnviron, MethodAuto:
+		stdout, err := r.exec(ctx, pod, container, []string{"cat", "/proc/1/environ"})
+		if err == nil {
+			envVars = ParseEnviron(stdout)
+			break
+		}
+		if method == MethodEnviron {
+			return nil, fmt.Errorf("failed to read /proc/1/environ: %w", err)
+		}
+		// Images without cat, or where PID 1 runs as another user
+		fallthrough
+	case MethodEnv:
+		stdout, err := r.exec(ctx, pod, container, []string{"env"})
+		if err != nil {
+			return nil, fmt.Errorf("failed to run env: %w", err)
+		}
+		envVars = ParseEnv(stdout)
+	default:
+		return nil, fmt.Errorf("invalid process method: %s", method)
+	}
+
+	origin := extractor.Origin{Kind: "Pod", Namespace: pod.Namespace, Name: pod.Name, Container: container}
+	for i := range envVars {
+		envVars[i].Origin = origin
+	}
+	return envVars, nil
+}
+
+// exec runs the command in the container and returns what it wrote to
+// stdout. The error includes what it wrote to stderr.
+func (r *Reader) exec(ctx context.Context, pod *corev1.Pod, container string, command []string) ([]byte, error) {
+	request := r.client.Post().
+		Resource("pods").
+		Namespace(pod.Namespace).
+		Name(pod.Name).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: container,
+			Command:   command,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+
+	executor, err := r.newExecutor(request.URL())
+	if err != nil {
+		return nil, err
+	}
+
+	var stdout, stderr bytes.Buffer
+	if err := executor.StreamWithContext(ctx, remotecommand.StreamOptions{Stdout: &stdout, Stderr: &stderr}); err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return nil, fmt.Errorf("%w: %s", err, message)
+		}
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
+
+// ParseEnviron parses the NUL-separated NAME=value entries of a
+// /proc/PID/environ file. Entries without = are skipped.
+func ParseEnviron(data []byte) []extractor.EnvVar {
+	var envVars []extractor.EnvVar
+	for _, entry := range strings.Split(string(data), "\x00") {
+		name, value, ok := strings.Cut(entry, "=")
+		if !ok || name == "" {
+			continue
+		}
+		envVars = append(envVars, extractor.EnvVar{Name: name, Value: value, Source: extractor.SourceDirect})
+	}
+	return envVars
+}
+
+// envLine matches a line of env output that starts a variable
+var envLine = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*=`)
+
+// ParseEnv parses the output of env. env does not quote values, so a line
+// that does not start with NAME= continues the value of the previous
+// variable; a value line that looks like NAME=value is read as a variable of
+// its own. ParseEnviron has no such ambiguity.
+func ParseEnv(data []byte) []extractor.EnvVar {
+	var envVars []extractor.EnvVar
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		if !envLine.MatchString(line) {
+			if len(envVars) > 0 {
+				envVars[len(envVars)-1].Value += "\n" + line
+			}
+			continue
+		}
+		name, value, _ := strings.Cut(line, "=")
+		envVars = append(envVars, extractor.EnvVar{Name: name, Value: value, Source: extractor.SourceDirect})
+	}
+	return envVars
+}
+
+// MatchSpec returns a copy of the process env vars where each variable the
+// spec defines for the same container takes its source, refs and secrecy
+// from the spec, so that a value read from a Secret stays secret
+func MatchSpec(process, spec []extractor.EnvVar) []extractor.EnvVar {
+	defined := make(map[string]extractor.EnvVar)
+	for _, env := range spec {
+		defined[env.Origin.Container+"/"+env.Name] = env
+	}
+
+	matched := make([]extractor.EnvVar, len(process))
+	for i, env := range process {
+		if specEnv, ok := defined[env.Origin.Container+"/"+env.Name]; ok {
+			env.Source = specEnv.Source
+			env.IsSecret = specEnv.IsSecret
+			env.SecretRef = specEnv.SecretRef
+			env.ConfigRef = specEnv.ConfigRef
+			env.FieldRef = specEnv.FieldRef
+			env.ResourceFieldRef = specEnv.ResourceFieldRef
+			env.Prefix = specEnv.Prefix
+			env.EnvFrom = specEnv.EnvFrom
+		}
+		matched[i] = env
+	}
+	return matched
+}
+
+var (
+	serviceLinkName = regexp.MustCompile(`^[A-Z0-9_]+_(SERVICE_HOST|SERVICE_PORT(_[A-Z0-9_]+)?|PORT_[0-9]+_(TCP|UDP|SCTP)(_PROTO|_PORT|_ADDR)?)$`)
+	serviceLinkPort = regexp.MustCompile(`^[A-Z0-9_]+_PORT$`)
+	serviceLinkURL  = regexp.MustCompile(`^(tcp|udp|sctp)://`)
+)
+
+// IsInjected reports whether the variable is one that every container gets
+// without the pod spec asking for it: HOSTNAME, set by the container runtime,
+// and the Docker-style service link variables the kubelet adds for each
+// Service, such as API_SERVICE_HOST and API_PORT=tcp://10.0.0.1:80
+func IsInjected(env extractor.EnvVar) bool {
+	switch {
+	case env.Name == "HOSTNAME":
+		return true
+	case serviceLinkName.MatchString(env.Name):
+		return true
+	default:
+		return serviceLinkPort.MatchString(env.Name) && serviceLinkURL.MatchString(env.Value)
+	}
+}
+
+// CompareOptions configures Compare
+type CompareOptions struct {
+	// Salt is prepended to secret values before they are hashed
+	Salt string
+	// Extra also reports the variables only the process has, except those
+	// IsInjected reports
+	Extra bool
+}
+
+// Compare returns the differences between the environment derived from the
+// pod spec and the one of the process. Only the variables the spec defines
+// are compared unless opts.Extra is set: the process also has PATH, HOME and
+// every ENV of the image, which the spec does not know about.
+func Compare(spec, process []extractor.EnvVar, opts CompareOptions) []differ.Change {
+	defined := make(map[string]bool, len(spec))
+	for _, env := range spec {
+		defined[env.Name] = true
+	}
+
+	var compared []extractor.EnvVar
+	for _, env := range process {
+		if defined[env.Name] || (opts.Extra && !IsInjected(env)) {
+			compared = append(compared, env)
+		}
+	}
+	return differ.Diff(spec, compared, differ.Options{Salt: opts.Salt})
+}
@@ -0,0 +1,251 @@
+package procenv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/whywaita/keex/pkg/differ"
+	"github.com/whywaita/keex/pkg/extractor"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// fakeExecutor answers exec requests from outputs keyed by the command
+type fakeExecutor struct {
+	url     *url.URL
+	outputs map[string]string
+}
+
+func (f *fakeExecutor) Stream(options remotecommand.StreamOptions) error {
+	return f.StreamWithContext(context.Background(), options)
+}
+
+func (f *fakeExecutor) StreamWithContext(ctx context.Context, options remotecommand.StreamOptions) error {
+	command := strings.Join(f.url.Query()["command"], " ")
+	output, ok := f.outputs[command]
+	if !ok {
+		fmt.Fprintf(options.Stderr, "%s: not found", command)
+		return errors.New("command terminated with exit code 127")
+	}
+	_, err := fmt.Fprint(options.Stdout, output)
+	return err
+}
+
+func newTestReader(t *testing.T, outputs map[string]string) (*Reader, *[]*url.URL) {
+	t.Helper()
+	client, err := rest.RESTClientFor(&rest.Config{
+		Host:    "https://cluster.example",
+		APIPath: "/api",
+		ContentConfig: rest.ContentConfig{
+			GroupVersion:         &corev1.SchemeGroupVersion,
+			NegotiatedSerializer: scheme.Codecs.WithoutConversion(),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var requests []*url.URL
+	reader := &Reader{
+		client: client,
+		newExecutor: func(url *url.URL) (remotecommand.Executor, error) {
+			requests = append(requests, url)
+			return &fakeExecutor{url: url, outputs: outputs}, nil
+		},
+	}
+	return reader, &requests
+}
+
+func runningPod() *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "api-1", Namespace: "prod"},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}, {Name: "vault-agent"}}},
+		Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+}
+
+func TestRead(t *testing.T) {
+	reader, requests := newTestReader(t, map[string]string{
+		"cat /proc/1/environ": "PATH=/usr/bin\x00DB_PASSWORD=from-vault\x00PEM=line1\nline2\x00",
+		"env":                 "PATH=/usr/bin\nDB_PASSWORD=from-spec\n",
+	})
+	ctx := context.Background()
+
+	envVars, err := reader.Read(ctx, runningPod(), "app", MethodAuto)
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if len(envVars) != 3 || envVars[1].Value != "from-vault" || envVars[2].Value != "line1\nline2" {
+		t.Fatalf("Read() = %+v", envVars)
+	}
+	expectedOrigin := extractor.Origin{Kind: "Pod", Namespace: "prod", Name: "api-1", Container: "app"}
+	if envVars[0].Origin != expectedOrigin || envVars[0].Source != extractor.SourceDirect {
+		t.Errorf("Read() origin = %+v", envVars[0])
+	}
+
+	request := (*requests)[0]
+	if request.Path != "/api/v1/namespaces/prod/pods/api-1/exec" {
+		t.Errorf("exec path = %s", request.Path)
+	}
+	query := request.Query()
+	if query.Get("container") != "app" || query.Get("stdout") != "true" || query.Get("stdin") != "" {
+		t.Errorf("exec query = %s", request.RawQuery)
+	}
+
+	envVars, err = reader.Read(ctx, runningPod(), "app", MethodEnv)
+	if err != nil || len(envVars) != 2 || envVars[1].Value != "from-spec" {
+		t.Errorf("Read(env) = %+v, %v", envVars, err)
+	}
+}
+
+func TestReadFallback(t *testing.T) {
+	reader, requests := newTestReader(t, map[string]string{"env": "FOO=bar\n"})
+	ctx := context.Background()
+
+	envVars, err := reader.Read(ctx, runningPod(), "app", MethodAuto)
+	if err != nil || len(envVars) != 1 || envVars[0].Name != "FOO" {
+		t.Fatalf("Read() = %+v, %v", envVars, err)
+	}
+	if len(*requests) != 2 {
+		t.Errorf("Read() made %d exec requests, want 2", len(*requests))
+	}
+
+	_, err = reader.Read(ctx, runningPod(), "app", MethodEnviron)
+	if err == nil || !strings.Contains(err.Error(), "cat /proc/1/environ: not found") {
+		t.Errorf("Read(environ) error = %v, want the command's stderr", err)
+	}
+
+	pending := runningPod()
+	pending.Status.Phase = corev1.PodPending
+	if _, err := reader.Read(ctx, pending, "app", MethodAuto); err == nil {
+		t.Error("Read() expected error for a pod that is not running")
+	}
+}
+
+func TestParseEnv(t *testing.T) {
+	envVars := ParseEnv([]byte("A=1\nCERT=-----BEGIN-----\nabc\n-----END-----\nEMPTY=\nB=x=y\n"))
+	expected := []struct{ name, value string }{
+		{"A", "1"},
+		{"CERT", "-----BEGIN-----\nabc\n-----END-----"},
+		{"EMPTY", ""},
+		{"B", "x=y"},
+	}
+	if len(envVars) != len(expected) {
+		t.Fatalf("ParseEnv() = %+v", envVars)
+	}
+	for i, want := range expected {
+		if envVars[i].Name != want.name || envVars[i].Value != want.value {
+			t.Errorf("ParseEnv()[%d] = %s=%q, want %s=%q", i, envVars[i].Name, envVars[i].Value, want.name, want.value)
+		}
+	}
+
+	if envVars := ParseEnviron([]byte("A=1\x00junk\x00B=\x00")); len(envVars) != 2 || envVars[1].Name != "B" {
+		t.Errorf("ParseEnviron() = %+v", envVars)
+	}
+}
+
+func TestMatchSpec(t *testing.T) {
+	origin := extractor.Origin{Container: "app"}
+	spec := []extractor.EnvVar{
+		{Name: "DB_PASSWORD", Source: extractor.SourceSecret, IsSecret: true, SecretRef: &extractor.SecretKeyRef{Name: "db", Key: "password"}, Origin: origin},
+		{Name: "SIDECAR", Source: extractor.SourceConfigMap, Origin: extractor.Origin{Container: "vault-agent"}},
+	}
+	process := []extractor.EnvVar{
+		{Name: "DB_PASSWORD", Value: "rotated", Source: extractor.SourceDirect, Origin: origin},
+		{Name: "SIDECAR", Value: "x", Source: extractor.SourceDirect, Origin: origin},
+	}
+
+	matched := MatchSpec(process, spec)
+	if !matched[0].IsSecret || matched[0].Source != extractor.SourceSecret || matched[0].Value != "rotated" {
+		t.Errorf("MatchSpec() = %+v, want the secret source with the process value", matched[0])
+	}
+	if matched[1].Source != extractor.SourceDirect {
+		t.Errorf("MatchSpec() matched a variable of another container: %+v", matched[1])
+	}
+	if process[0].IsSecret {
+		t.Error("MatchSpec() modified its input")
+	}
+}
+
+func TestIsInjected(t *testing.T) {
+	tests := []struct {
+		name, value string
+		injected    bool
+	}{
+		{"HOSTNAME", "api-1", true},
+		{"KUBERNETES_SERVICE_HOST", "10.0.0.1", true},
+		{"API_SERVICE_PORT_HTTP", "80", true},
+		{"API_PORT", "tcp://10.0.0.2:80", true},
+		{"API_PORT_80_TCP_ADDR", "10.0.0.2", true},
+		{"APP_PORT", "8080", false},
+		{"DB_PASSWORD", "x", false},
+	}
+	for _, tt := range tests {
+		if got := IsInjected(extractor.EnvVar{Name: tt.name, Value: tt.value}); got != tt.injected {
+			t.Errorf("IsInjected(%s=%s) = %v, want %v", tt.name, tt.value, got, tt.injected)
+		}
+	}
+}
+
+func TestDefaultContainer(t *testing.T) {
+	pod := runningPod()
+	if got := DefaultContainer(pod); got != "app" {
+		t.Errorf("DefaultContainer() = %s, want app", got)
+	}
+	pod.Annotations = map[string]string{DefaultContainerAnnotation: "vault-agent"}
+	if got := DefaultContainer(pod); got != "vault-agent" {
+		t.Errorf("DefaultContainer() = %s, want vault-agent", got)
+	}
+	if _, err := ParseMethod("proc"); err == nil {
+		t.Error("ParseMethod() expected error for an unknown method")
+	}
+}
+
+func TestCompare(t *testing.T) {
+	origin := extractor.Origin{Container: "app"}
+	spec := []extractor.EnvVar{
+		{Name: "LOG_LEVEL", Value: "info", Source: extractor.SourceDirect, Origin: origin},
+		{Name: "DB_PASSWORD", Value: "hunter2", Source: extractor.SourceSecret, IsSecret: true, Origin: origin},
+	}
+	process := MatchSpec([]extractor.EnvVar{
+		{Name: "PATH", Value: "/usr/local/bin:/usr/bin", Source: extractor.SourceDirect, Origin: origin},
+		{Name: "HOME", Value: "/root", Source: extractor.SourceDirect, Origin: origin},
+		{Name: "HOSTNAME", Value: "api-1", Source: extractor.SourceDirect, Origin: origin},
+		{Name: "LOG_LEVEL", Value: "info", Source: extractor.SourceDirect, Origin: origin},
+		{Name: "DB_PASSWORD", Value: "hunter2", Source: extractor.SourceDirect, Origin: origin},
+		{Name: "VAULT_TOKEN", Value: "injected", Source: extractor.SourceDirect, Origin: origin},
+	}, spec)
+
+	// The image's variables do not keep the process from matching the spec
+	if changes := Compare(spec, process, CompareOptions{Salt: "pepper"}); len(changes) != 0 {
+		t.Errorf("Compare() = %+v, want the process to match the spec", changes)
+	}
+
+	changes := Compare(spec, process, CompareOptions{Salt: "pepper", Extra: true})
+	var added []string
+	for _, change := range changes {
+		if change.Type != differ.Added {
+			t.Errorf("Compare(extra) = %+v, want only added variables", change)
+		}
+		added = append(added, change.Name)
+	}
+	if strings.Join(added, ",") != "HOME,PATH,VAULT_TOKEN" {
+		t.Errorf("Compare(extra) added %v, want HOME, PATH and VAULT_TOKEN", added)
+	}
+
+	rotated := MatchSpec([]extractor.EnvVar{
+		{Name: "LOG_LEVEL", Value: "info", Source: extractor.SourceDirect, Origin: origin},
+		{Name: "DB_PASSWORD", Value: "rotated", Source: extractor.SourceDirect, Origin: origin},
+	}, spec)
+	changes = Compare(spec, rotated, CompareOptions{Salt: "pepper"})
+	if len(changes) != 1 || changes[0].Name != "DB_PASSWORD" || !changes[0].Secret || strings.Contains(changes[0].New, "rotated") {
+		t.Errorf("Compare() = %+v, want DB_PASSWORD changed by fingerprint", changes)
+	}
+}
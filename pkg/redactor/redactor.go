@@ -0,0 +1,162 @@
+package redactor
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/whywaita/keex/pkg/extractor"
+)
+
+// Policy decides how a redacted value is masked
+type Policy string
+
+const (
+	// PolicyFull replaces the value with a fixed marker
+	PolicyFull Policy = "full"
+	// PolicyPartial keeps the first and last two characters, ab***yz
+	PolicyPartial Policy = "partial"
+	// PolicyLength replaces every character with *, keeping the length
+	PolicyLength Policy = "length"
+	// PolicyHash replaces the value with a salted SHA-256 fingerprint, so
+	// values can be compared without being exposed
+	PolicyHash Policy = "hash"
+)
+
+// Policies lists the supported policies
+var Policies = []Policy{PolicyFull, PolicyPartial, PolicyLength, PolicyHash}
+
+// Mask is what PolicyFull writes instead of a value
+const Mask = "***REDACTED***"
+
+// Options configures a Redactor
+type Options struct {
+	Policy Policy
+	// Salt is prepended to values before hashing with PolicyHash. Use the
+	// same salt for environments that are compared. PolicyHash generates a
+	// random salt when it is empty, an unsalted hash of a short secret is
+	// easily reversed with a dictionary.
+	Salt string
+	// Names and Patterns force redaction of matching variables, even if the
+	// value does not come from a Secret
+	Names    []string
+	Patterns []string
+}
+
+// Redactor masks secret values
+type Redactor struct {
+	policy   Policy
+	salt     string
+	names    map[string]bool
+	patterns []*regexp.Regexp
+}
+
+// New returns a Redactor. An empty policy means PolicyFull.
+func New(opts Options) (*Redactor, error) {
+	r := &Redactor{
+		policy: opts.Policy,
+		salt:   opts.Salt,
+		names:  make(map[string]bool, len(opts.Names)),
+	}
+	if r.policy == "" {
+		r.policy = PolicyFull
+	}
+	if !isValidPolicy(r.policy) {
+		return nil, fmt.Errorf("invalid redaction policy: %s (must be one of %s)", r.policy, strings.Join(PolicyNames(), ", "))
+	}
+	if r.policy == PolicyHash && r.salt == "" {
+		random := make([]byte, 16)
+		if _, err := rand.Read(random); err != nil {
+			return nil, fmt.Errorf("failed to generate salt: %w", err)
+		}
+		r.salt = hex.EncodeToString(random)
+	}
+
+	for _, name := range opts.Names {
+		r.names[name] = true
+	}
+	for _, pattern := range opts.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction pattern %s: %w", pattern, err)
+		}
+		r.patterns = append(r.patterns, re)
+	}
+
+	return r, nil
+}
+
+// PolicyNames returns the names of the supported policies
+func PolicyNames() []string {
+	names := make([]string, len(Policies))
+	for i, policy := range Policies {
+		names[i] = string(policy)
+	}
+	return names
+}
+
+func isValidPolicy(policy Policy) bool {
+	for _, p := range Policies {
+		if p == policy {
+			return true
+		}
+	}
+	return false
+}
+
+// Redact returns a copy of envVars where the values of secrets and of
+// variables matching a name or pattern are masked and marked as Redacted
+func (r *Redactor) Redact(envVars []extractor.EnvVar) []extractor.EnvVar {
+	redacted := make([]extractor.EnvVar, len(envVars))
+	for i, env := range envVars {
+		// Comment entries carry no value
+		if !strings.HasPrefix(env.Name, "#") && r.matches(env) {
+			env.Value = r.Mask(env.Value)
+			env.Redacted = true
+		}
+		redacted[i] = env
+	}
+	return redacted
+}
+
+// matches reports whether the variable must be redacted
+func (r *Redactor) matches(env extractor.EnvVar) bool {
+	if env.IsSecret || r.names[env.Name] {
+		return true
+	}
+	for _, re := range r.patterns {
+		if re.MatchString(env.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Salt returns the salt PolicyHash prepends to values, the one given in
+// Options or a random one
+func (r *Redactor) Salt() string {
+	return r.salt
+}
+
+// Mask masks value with the policy of the Redactor
+func (r *Redactor) Mask(value string) string {
+	switch r.policy {
+	case PolicyPartial:
+		runes := []rune(value)
+		// Short values would be revealed almost entirely
+		if len(runes) < 8 {
+			return "***"
+		}
+		return string(runes[:2]) + "***" + string(runes[len(runes)-2:])
+	case PolicyLength:
+		return strings.Repeat("*", len([]rune(value)))
+	case PolicyHash:
+		sum := sha256.Sum256([]byte(r.salt + value))
+		return "sha256:" + hex.EncodeToString(sum[:])
+	default:
+		return Mask
+	}
+}
@@ -0,0 +1,115 @@
+package redactor
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/whywaita/keex/pkg/extractor"
+)
+
+func TestMask(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   Policy
+		value    string
+		expected string
+	}{
+		{name: "full", policy: PolicyFull, value: "hunter2", expected: Mask},
+		{name: "default is full", policy: "", value: "hunter2", expected: Mask},
+		{name: "partial", policy: PolicyPartial, value: "abcdefghyz", expected: "ab***yz"},
+		{name: "partial short value", policy: PolicyPartial, value: "hunter2", expected: "***"},
+		{name: "partial multibyte", policy: PolicyPartial, value: "ééabcdefçç", expected: "éé***çç"},
+		{name: "length", policy: PolicyLength, value: "hunter2", expected: "*******"},
+		{name: "length multibyte", policy: PolicyLength, value: "café", expected: "****"},
+		{name: "length empty", policy: PolicyLength, value: "", expected: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := New(Options{Policy: tt.policy})
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			if got := r.Mask(tt.value); got != tt.expected {
+				t.Errorf("Mask(%q) = %q, want %q", tt.value, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestMaskHashSalt(t *testing.T) {
+	salted, _ := New(Options{Policy: PolicyHash, Salt: "pepper"})
+	same, _ := New(Options{Policy: PolicyHash, Salt: "pepper"})
+
+	if got := salted.Mask("hunter2"); got != "sha256:ca458f67a1e64e60f40414c062c57abbfc1d41b5d0c30cd07d12704540067f21" {
+		t.Errorf("Mask() = %s, want the SHA-256 of salt and value", got)
+	}
+	if salted.Mask("hunter2") != same.Mask("hunter2") {
+		t.Error("Mask() with the same salt should be stable")
+	}
+	if salted.Mask("hunter2") == salted.Mask("hunter3") {
+		t.Error("Mask() should differ for different values")
+	}
+
+	// Without a salt every redactor gets a random one
+	unsalted, _ := New(Options{Policy: PolicyHash})
+	other, _ := New(Options{Policy: PolicyHash})
+	if unsalted.Salt() == "" || unsalted.Salt() == other.Salt() {
+		t.Errorf("Salt() = %q and %q, want different random salts", unsalted.Salt(), other.Salt())
+	}
+	if unsalted.Mask("hunter2") == other.Mask("hunter2") || unsalted.Mask("hunter2") == salted.Mask("hunter2") {
+		t.Error("Mask() without a salt should use a random one")
+	}
+	if full, _ := New(Options{}); full.Salt() != "" {
+		t.Errorf("Salt() = %q, want no salt for policies that do not hash", full.Salt())
+	}
+}
+
+func TestRedact(t *testing.T) {
+	envVars := []extractor.EnvVar{
+		{Name: "DB_PASSWORD", Value: "from-secret", Source: extractor.SourceSecret, IsSecret: true},
+		{Name: "API_TOKEN", Value: "inline-token", Source: extractor.SourceDirect},
+		{Name: "STRIPE_KEY", Value: "inline-key", Source: extractor.SourceDirect},
+		{Name: "LOG_LEVEL", Value: "debug", Source: extractor.SourceDirect},
+		{Name: "# from secret: extra", Source: extractor.SourceSecret, IsSecret: true},
+	}
+
+	r, err := New(Options{Names: []string{"API_TOKEN"}, Patterns: []string{`_KEY$`}})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	redacted := r.Redact(envVars)
+
+	expected := map[string]bool{
+		"DB_PASSWORD":          true,
+		"API_TOKEN":            true,
+		"STRIPE_KEY":           true,
+		"LOG_LEVEL":            false,
+		"# from secret: extra": false,
+	}
+	for i, env := range redacted {
+		if env.Redacted != expected[env.Name] {
+			t.Errorf("%s: Redacted = %v, want %v", env.Name, env.Redacted, expected[env.Name])
+		}
+		if env.Redacted && env.Value != Mask {
+			t.Errorf("%s: Value = %q, want %q", env.Name, env.Value, Mask)
+		}
+		if !env.Redacted && env.Value != envVars[i].Value {
+			t.Errorf("%s: Value = %q, want %q", env.Name, env.Value, envVars[i].Value)
+		}
+	}
+
+	// The input is left untouched
+	if envVars[0].Value != "from-secret" || envVars[0].Redacted {
+		t.Errorf("Redact() modified its input: %+v", envVars[0])
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	if _, err := New(Options{Policy: "rot13"}); err == nil || !strings.Contains(err.Error(), "full, partial, length, hash") {
+		t.Errorf("New() error = %v, want invalid policy error", err)
+	}
+	if _, err := New(Options{Patterns: []string{"("}}); err == nil {
+		t.Error("New() expected error for invalid pattern")
+	}
+}
@@ -0,0 +1,88 @@
+package resolver
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/whywaita/keex/pkg/extractor"
+)
+
+// Shadowing describes an env var that was overridden by another definition of
+// the same name.
+type Shadowing struct {
+	Name       string
+	Winner     extractor.EnvVar
+	Overridden extractor.EnvVar
+}
+
+func (s Shadowing) String() string {
+	return fmt.Sprintf("%s from %s is overridden by %s", s.Name, describeOrigin(s.Overridden), describeOrigin(s.Winner))
+}
+
+// envKey identifies an env var within its container
+type envKey struct {
+	origin extractor.Origin
+	name   string
+}
+
+// Deduplicate removes duplicate env vars with the kubelet's precedence rules:
+// values from later envFrom sources override earlier ones, and explicit env
+// entries override every envFrom value. Each container is handled on its own.
+// The surviving entries keep their relative order. Every dropped entry is
+// reported as a Shadowing.
+func Deduplicate(envVars []extractor.EnvVar) ([]extractor.EnvVar, []Shadowing) {
+	// Index of the winning entry for each name
+	winners := make(map[envKey]int, len(envVars))
+	for i, env := range envVars {
+		if isComment(env) {
+			continue
+		}
+		key := envKey{origin: env.Origin, name: env.Name}
+		current, ok := winners[key]
+		if !ok || !env.EnvFrom || envVars[current].EnvFrom {
+			winners[key] = i
+		}
+	}
+
+	var shadowings []Shadowing
+	deduplicated := make([]extractor.EnvVar, 0, len(winners))
+	for i, env := range envVars {
+		if isComment(env) {
+			deduplicated = append(deduplicated, env)
+			continue
+		}
+		winner := winners[envKey{origin: env.Origin, name: env.Name}]
+		if winner != i {
+			shadowings = append(shadowings, Shadowing{
+				Name:       env.Name,
+				Winner:     envVars[winner],
+				Overridden: env,
+			})
+			continue
+		}
+		deduplicated = append(deduplicated, env)
+	}
+
+	return deduplicated, shadowings
+}
+
+func describeOrigin(env extractor.EnvVar) string {
+	var origin string
+	switch {
+	case env.SecretRef != nil:
+		origin = fmt.Sprintf("secret %s", env.SecretRef.Name)
+	case env.ConfigRef != nil:
+		origin = fmt.Sprintf("configmap %s", env.ConfigRef.Name)
+	default:
+		origin = "value"
+	}
+
+	if env.EnvFrom {
+		return "envFrom " + origin
+	}
+	return "env " + origin
+}
+
+func isComment(env extractor.EnvVar) bool {
+	return strings.HasPrefix(env.Name, "#")
+}
@@ -0,0 +1,55 @@
+package resolver
+
+import (
+	"testing"
+
+	"github.com/whywaita/keex/pkg/extractor"
+)
+
+func TestDeduplicate(t *testing.T) {
+	envVars := []extractor.EnvVar{
+		{Name: "LOG_LEVEL", Value: "debug", Source: extractor.SourceDirect},
+		{Name: "APP_ENV", Value: "staging", Source: extractor.SourceDirect},
+		{Name: "APP_ENV", Value: "production", Source: extractor.SourceDirect},
+		{Name: "LOG_LEVEL", Value: "info", Source: extractor.SourceConfigMap, EnvFrom: true, ConfigRef: &extractor.ConfigMapKeyRef{Name: "base", Key: "LOG_LEVEL"}},
+		{Name: "REGION", Value: "us", Source: extractor.SourceConfigMap, EnvFrom: true, ConfigRef: &extractor.ConfigMapKeyRef{Name: "base", Key: "REGION"}},
+		{Name: "REGION", Value: "eu", Source: extractor.SourceConfigMap, EnvFrom: true, ConfigRef: &extractor.ConfigMapKeyRef{Name: "override", Key: "REGION"}},
+	}
+
+	deduplicated, shadowings := Deduplicate(envVars)
+
+	expected := []struct{ name, value string }{
+		{"LOG_LEVEL", "debug"},
+		{"APP_ENV", "production"},
+		{"REGION", "eu"},
+	}
+	if len(deduplicated) != len(expected) {
+		t.Fatalf("Deduplicate() got %d env vars, want %d", len(deduplicated), len(expected))
+	}
+	for i, want := range expected {
+		if deduplicated[i].Name != want.name || deduplicated[i].Value != want.value {
+			t.Errorf("Deduplicate()[%d] = %s=%s, want %s=%s", i, deduplicated[i].Name, deduplicated[i].Value, want.name, want.value)
+		}
+	}
+
+	if len(shadowings) != 3 {
+		t.Fatalf("Deduplicate() got %d shadowings, want 3", len(shadowings))
+	}
+	if got, want := shadowings[2].String(), "REGION from envFrom configmap base is overridden by envFrom configmap override"; got != want {
+		t.Errorf("Shadowing.String() = %q, want %q", got, want)
+	}
+}
+
+func TestDeduplicate_PerContainer(t *testing.T) {
+	app := extractor.Origin{Kind: "Pod", Name: "web", Container: "app"}
+	sidecar := extractor.Origin{Kind: "Pod", Name: "web", Container: "sidecar"}
+
+	deduplicated, shadowings := Deduplicate([]extractor.EnvVar{
+		{Name: "PORT", Value: "8080", Source: extractor.SourceDirect, Origin: app},
+		{Name: "PORT", Value: "9090", Source: extractor.SourceDirect, Origin: sidecar},
+	})
+
+	if len(deduplicated) != 2 || len(shadowings) != 0 {
+		t.Errorf("Deduplicate() = %v, %v; want both entries kept", deduplicated, shadowings)
+	}
+}
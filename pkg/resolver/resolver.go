@@ -6,9 +6,11 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 
 	"github.com/whywaita/keex/pkg/extractor"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
@@ -16,8 +18,15 @@ import (
 )
 
 type Resolver struct {
-	client    kubernetes.Interface
+	client kubernetes.Interface
+	// namespace is used for resources that do not set metadata.namespace
 	namespace string
+	// override, when set, replaces the namespace of every resource
+	override string
+
+	// Objects supplied in the manifest stream take precedence over the cluster
+	secrets    map[string]*corev1.Secret
+	configMaps map[string]*corev1.ConfigMap
 }
 
 type Options struct {
@@ -26,6 +35,24 @@ type Options struct {
 }
 
 func New(opts Options) (*Resolver, error) {
+	clientset, namespace, err := NewClientset(opts.Context)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Namespace != "" {
+		namespace = opts.Namespace
+	}
+
+	return &Resolver{
+		client:    clientset,
+		namespace: namespace,
+		override:  opts.Namespace,
+	}, nil
+}
+
+// NewClientset returns a client for the kubeconfig context, the current
+// context when kubeContext is empty, along with the namespace of the context
+func NewClientset(kubeContext string) (kubernetes.Interface, string, error) {
 	kubeconfig := os.Getenv("KUBECONFIG")
 	if kubeconfig == "" {
 		home := homedir.HomeDir()
@@ -35,8 +62,8 @@ func New(opts Options) (*Resolver, error) {
 	// Build config
 	configLoadingRules := &clientcmd.ClientConfigLoadingRules{ExplicitPath: kubeconfig}
 	configOverrides := &clientcmd.ConfigOverrides{}
-	if opts.Context != "" {
-		configOverrides.CurrentContext = opts.Context
+	if kubeContext != "" {
+		configOverrides.CurrentContext = kubeContext
 	}
 
 	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
@@ -46,59 +73,206 @@ func New(opts Options) (*Resolver, error) {
 
 	config, err := clientConfig.ClientConfig()
 	if err != nil {
-		return nil, fmt.Errorf("failed to build kubeconfig: %w", err)
+		return nil, "", fmt.Errorf("failed to build kubeconfig: %w", err)
 	}
 
 	// Create client
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create kubernetes client: %w", err)
+		return nil, "", fmt.Errorf("failed to create kubernetes client: %w", err)
 	}
 
 	// Get namespace
-	namespace := opts.Namespace
-	if namespace == "" {
-		namespace, _, err = clientConfig.Namespace()
-		if err != nil {
-			namespace = "default"
-		}
+	namespace, _, err := clientConfig.Namespace()
+	if err != nil {
+		namespace = "default"
 	}
+	return clientset, namespace, nil
+}
 
+func NewFromClientset(clientset kubernetes.Interface, namespace string) *Resolver {
 	return &Resolver{
 		client:    clientset,
 		namespace: namespace,
-	}, nil
+	}
 }
 
-func NewFromClientset(clientset kubernetes.Interface, namespace string) *Resolver {
-	return &Resolver{
-		client:    clientset,
+// NewOffline creates a Resolver without a cluster connection. It only resolves
+// references from objects registered with AddObjects. A non-empty namespace
+// overrides the namespace of every resource.
+func NewOffline(namespace string) *Resolver {
+	r := &Resolver{
 		namespace: namespace,
+		override:  namespace,
+	}
+	if r.namespace == "" {
+		r.namespace = "default"
+	}
+	return r
+}
+
+// namespaceFor returns the namespace refs of a resource in the given
+// namespace are resolved in
+func (r *Resolver) namespaceFor(namespace string) string {
+	if r.override != "" {
+		return r.override
+	}
+	if namespace != "" {
+		return namespace
+	}
+	return r.namespace
+}
+
+// objectKey returns the lookup key of an in-stream object. Objects without a
+// namespace keep an empty one: they land in whatever namespace the bundle is
+// applied to, so they match refs from any namespace (see lookupKeys).
+func objectKey(namespace, name string) string {
+	return namespace + "/" + name
+}
+
+// lookupKeys returns the keys an in-stream object referenced from the given
+// namespace may be registered under, in order of preference: an object in
+// that namespace, then one without a namespace.
+func lookupKeys(namespace, name string) []string {
+	return []string{objectKey(namespace, name), objectKey("", name)}
+}
+
+// AddObjects registers Secrets and ConfigMaps found in the manifest stream.
+// References to these objects are resolved locally; everything else falls
+// back to the cluster when a client is available.
+func (r *Resolver) AddObjects(secrets []*corev1.Secret, configMaps []*corev1.ConfigMap) {
+	if r.secrets == nil {
+		r.secrets = make(map[string]*corev1.Secret)
+	}
+	if r.configMaps == nil {
+		r.configMaps = make(map[string]*corev1.ConfigMap)
+	}
+
+	for _, secret := range secrets {
+		r.secrets[objectKey(secret.Namespace, secret.Name)] = mergeStringData(secret)
+	}
+	for _, configMap := range configMaps {
+		r.configMaps[objectKey(configMap.Namespace, configMap.Name)] = configMap
+	}
+}
+
+// mergeStringData returns a copy of the secret with stringData folded into
+// data, as the API server does when the object is written.
+func mergeStringData(secret *corev1.Secret) *corev1.Secret {
+	if len(secret.StringData) == 0 {
+		return secret
+	}
+
+	merged := secret.DeepCopy()
+	if merged.Data == nil {
+		merged.Data = make(map[string][]byte, len(secret.StringData))
 	}
+	for key, value := range secret.StringData {
+		merged.Data[key] = []byte(value)
+	}
+	merged.StringData = nil
+
+	return merged
 }
 
+// getSecret returns the named secret from the manifest stream or the cluster.
+// It returns nil without an error when the secret is not in the stream and no
+// cluster is available.
+func (r *Resolver) getSecret(ctx context.Context, namespace, name string) (*corev1.Secret, error) {
+	for _, key := range lookupKeys(namespace, name) {
+		if secret, ok := r.secrets[key]; ok {
+			return secret, nil
+		}
+	}
+	if r.client == nil {
+		return nil, nil
+	}
+
+	return r.client.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+}
+
+// getConfigMap returns the named configmap from the manifest stream or the
+// cluster. It returns nil without an error when the configmap is not in the
+// stream and no cluster is available.
+func (r *Resolver) getConfigMap(ctx context.Context, namespace, name string) (*corev1.ConfigMap, error) {
+	for _, key := range lookupKeys(namespace, name) {
+		if configMap, ok := r.configMaps[key]; ok {
+			return configMap, nil
+		}
+	}
+	if r.client == nil {
+		return nil, nil
+	}
+
+	return r.client.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+}
+
+// configMapData returns the string values of a configmap. Keys that only
+// exist in binaryData are included as raw bytes.
+func configMapData(configMap *corev1.ConfigMap) map[string]string {
+	data := make(map[string]string, len(configMap.Data)+len(configMap.BinaryData))
+	for key, value := range configMap.BinaryData {
+		data[key] = string(value)
+	}
+	for key, value := range configMap.Data {
+		data[key] = value
+	}
+	return data
+}
+
+// MissingRefsError is returned by ResolveAll when required Secrets,
+// ConfigMaps or keys do not exist. The env vars returned alongside it keep
+// placeholder values for the missing refs.
+type MissingRefsError struct {
+	Refs []string
+}
+
+func (e *MissingRefsError) Error() string {
+	return fmt.Sprintf("missing required references: %s", strings.Join(e.Refs, ", "))
+}
+
+// ResolveAll replaces secret and configmap refs with their values and expands
+// envFrom entries. Missing optional refs are dropped as the kubelet does;
+// missing required refs keep their placeholder and are reported with a
+// *MissingRefsError after all env vars have been processed.
 func (r *Resolver) ResolveAll(envVars []extractor.EnvVar) ([]extractor.EnvVar, error) {
 	ctx := context.Background()
 	resolved := make([]extractor.EnvVar, 0, len(envVars))
+	var missing []string
 
 	// Cache for secrets and configmaps
 	secretCache := make(map[string]*corev1.Secret)
 	configMapCache := make(map[string]*corev1.ConfigMap)
 
 	for _, envVar := range envVars {
+		namespace := r.namespaceFor(envVar.Origin.Namespace)
+
 		switch envVar.Source {
 		case extractor.SourceSecret:
 			if envVar.SecretRef != nil {
-				secret, ok := secretCache[envVar.SecretRef.Name]
+				cacheKey := namespace + "/" + envVar.SecretRef.Name
+				secret, ok := secretCache[cacheKey]
 				if !ok {
 					var err error
-					secret, err = r.client.CoreV1().Secrets(r.namespace).Get(ctx, envVar.SecretRef.Name, metav1.GetOptions{})
+					secret, err = r.getSecret(ctx, namespace, envVar.SecretRef.Name)
+					if apierrors.IsNotFound(err) {
+						if !envVar.SecretRef.Optional {
+							missing = append(missing, fmt.Sprintf("secret %s", cacheKey))
+							resolved = append(resolved, envVar)
+						}
+						continue
+					}
 					if err != nil {
-						fmt.Fprintf(os.Stderr, "Warning: failed to get secret %s: %v\n", envVar.SecretRef.Name, err)
+						fmt.Fprintf(os.Stderr, "Warning: failed to get secret %s: %v\n", cacheKey, err)
 						resolved = append(resolved, envVar)
 						continue
 					}
-					secretCache[envVar.SecretRef.Name] = secret
+					if secret == nil {
+						// Not in the manifest and no cluster available, keep the placeholder
+						resolved = append(resolved, envVar)
+						continue
+					}
+					secretCache[cacheKey] = secret
 				}
 
 				// Handle envFrom (when Key is "*")
@@ -118,13 +292,18 @@ func (r *Resolver) ResolveAll(envVars []extractor.EnvVar) ([]extractor.EnvVar, e
 							envName = envVar.Prefix + key
 						}
 						newEnvVar := extractor.EnvVar{
-							Name:   envName,
-							Value:  string(value),
-							Source: extractor.SourceSecret,
+							Name:     envName,
+							Value:    string(value),
+							Source:   extractor.SourceSecret,
+							IsSecret: true,
 							SecretRef: &extractor.SecretKeyRef{
-								Name: envVar.SecretRef.Name,
-								Key:  key,
+								Name:     envVar.SecretRef.Name,
+								Key:      key,
+								Optional: envVar.SecretRef.Optional,
 							},
+							Prefix:  envVar.Prefix,
+							EnvFrom: true,
+							Origin:  envVar.Origin,
 						}
 						resolved = append(resolved, newEnvVar)
 					}
@@ -132,8 +311,10 @@ func (r *Resolver) ResolveAll(envVars []extractor.EnvVar) ([]extractor.EnvVar, e
 					// Handle specific key reference
 					if value, ok := secret.Data[envVar.SecretRef.Key]; ok {
 						envVar.Value = string(value)
+					} else if envVar.SecretRef.Optional {
+						continue
 					} else {
-						fmt.Fprintf(os.Stderr, "Warning: key %s not found in secret %s\n", envVar.SecretRef.Key, envVar.SecretRef.Name)
+						missing = append(missing, fmt.Sprintf("key %s in secret %s", envVar.SecretRef.Key, cacheKey))
 					}
 					resolved = append(resolved, envVar)
 				}
@@ -143,30 +324,45 @@ func (r *Resolver) ResolveAll(envVars []extractor.EnvVar) ([]extractor.EnvVar, e
 
 		case extractor.SourceConfigMap:
 			if envVar.ConfigRef != nil {
-				configMap, ok := configMapCache[envVar.ConfigRef.Name]
+				cacheKey := namespace + "/" + envVar.ConfigRef.Name
+				configMap, ok := configMapCache[cacheKey]
 				if !ok {
 					var err error
-					configMap, err = r.client.CoreV1().ConfigMaps(r.namespace).Get(ctx, envVar.ConfigRef.Name, metav1.GetOptions{})
+					configMap, err = r.getConfigMap(ctx, namespace, envVar.ConfigRef.Name)
+					if apierrors.IsNotFound(err) {
+						if !envVar.ConfigRef.Optional {
+							missing = append(missing, fmt.Sprintf("configmap %s", cacheKey))
+							resolved = append(resolved, envVar)
+						}
+						continue
+					}
 					if err != nil {
-						fmt.Fprintf(os.Stderr, "Warning: failed to get configmap %s: %v\n", envVar.ConfigRef.Name, err)
+						fmt.Fprintf(os.Stderr, "Warning: failed to get configmap %s: %v\n", cacheKey, err)
+						resolved = append(resolved, envVar)
+						continue
+					}
+					if configMap == nil {
+						// Not in the manifest and no cluster available, keep the placeholder
 						resolved = append(resolved, envVar)
 						continue
 					}
-					configMapCache[envVar.ConfigRef.Name] = configMap
+					configMapCache[cacheKey] = configMap
 				}
 
+				data := configMapData(configMap)
+
 				// Handle envFrom (when Key is "*")
 				if envVar.ConfigRef.Key == "*" {
 					// Extract all key-value pairs from the configmap
 					// Sort keys for consistent output
-					keys := make([]string, 0, len(configMap.Data))
-					for key := range configMap.Data {
+					keys := make([]string, 0, len(data))
+					for key := range data {
 						keys = append(keys, key)
 					}
 					sort.Strings(keys)
 
 					for _, key := range keys {
-						value := configMap.Data[key]
+						value := data[key]
 						envName := key
 						if envVar.Prefix != "" {
 							envName = envVar.Prefix + key
@@ -176,18 +372,24 @@ func (r *Resolver) ResolveAll(envVars []extractor.EnvVar) ([]extractor.EnvVar, e
 							Value:  value,
 							Source: extractor.SourceConfigMap,
 							ConfigRef: &extractor.ConfigMapKeyRef{
-								Name: envVar.ConfigRef.Name,
-								Key:  key,
+								Name:     envVar.ConfigRef.Name,
+								Key:      key,
+								Optional: envVar.ConfigRef.Optional,
 							},
+							Prefix:  envVar.Prefix,
+							EnvFrom: true,
+							Origin:  envVar.Origin,
 						}
 						resolved = append(resolved, newEnvVar)
 					}
 				} else {
 					// Handle specific key reference
-					if value, ok := configMap.Data[envVar.ConfigRef.Key]; ok {
+					if value, ok := data[envVar.ConfigRef.Key]; ok {
 						envVar.Value = value
+					} else if envVar.ConfigRef.Optional {
+						continue
 					} else {
-						fmt.Fprintf(os.Stderr, "Warning: key %s not found in configmap %s\n", envVar.ConfigRef.Key, envVar.ConfigRef.Name)
+						missing = append(missing, fmt.Sprintf("key %s in configmap %s", envVar.ConfigRef.Key, cacheKey))
 					}
 					resolved = append(resolved, envVar)
 				}
@@ -200,5 +402,9 @@ func (r *Resolver) ResolveAll(envVars []extractor.EnvVar) ([]extractor.EnvVar, e
 		}
 	}
 
+	if len(missing) > 0 {
+		return resolved, &MissingRefsError{Refs: missing}
+	}
+
 	return resolved, nil
 }
@@ -0,0 +1,265 @@
+package resolver
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/whywaita/keex/pkg/extractor"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestResolver_ResolveAll_InStreamObjects(t *testing.T) {
+	secrets := []*corev1.Secret{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "db-secret"},
+			Data:       map[string][]byte{"username": []byte("admin")},
+			StringData: map[string]string{"password": "s3cr3t"},
+		},
+	}
+	configMaps := []*corev1.ConfigMap{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "app-config"},
+			Data:       map[string]string{"config-path": "/etc/app"},
+			BinaryData: map[string][]byte{"blob": []byte("raw")},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		envVars  []extractor.EnvVar
+		expected map[string]string
+	}{
+		{
+			name: "secret data and stringData",
+			envVars: []extractor.EnvVar{
+				{Name: "DB_USER", Value: "<db-secret:username>", Source: extractor.SourceSecret, SecretRef: &extractor.SecretKeyRef{Name: "db-secret", Key: "username"}},
+				{Name: "DB_PASS", Value: "<db-secret:password>", Source: extractor.SourceSecret, SecretRef: &extractor.SecretKeyRef{Name: "db-secret", Key: "password"}},
+			},
+			expected: map[string]string{"DB_USER": "admin", "DB_PASS": "s3cr3t"},
+		},
+		{
+			name: "configmap data and binaryData",
+			envVars: []extractor.EnvVar{
+				{Name: "CONFIG_PATH", Value: "<app-config:config-path>", Source: extractor.SourceConfigMap, ConfigRef: &extractor.ConfigMapKeyRef{Name: "app-config", Key: "config-path"}},
+				{Name: "BLOB", Value: "<app-config:blob>", Source: extractor.SourceConfigMap, ConfigRef: &extractor.ConfigMapKeyRef{Name: "app-config", Key: "blob"}},
+			},
+			expected: map[string]string{"CONFIG_PATH": "/etc/app", "BLOB": "raw"},
+		},
+		{
+			name: "envFrom secret with prefix",
+			envVars: []extractor.EnvVar{
+				{Name: "# from secret: db-secret", Source: extractor.SourceSecret, SecretRef: &extractor.SecretKeyRef{Name: "db-secret", Key: "*"}, Prefix: "DB_"},
+			},
+			expected: map[string]string{"DB_password": "s3cr3t", "DB_username": "admin"},
+		},
+		{
+			name: "unknown ref keeps placeholder without cluster",
+			envVars: []extractor.EnvVar{
+				{Name: "API_KEY", Value: "<api-secret:key>", Source: extractor.SourceSecret, SecretRef: &extractor.SecretKeyRef{Name: "api-secret", Key: "key"}},
+			},
+			expected: map[string]string{"API_KEY": "<api-secret:key>"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewOffline("")
+			r.AddObjects(secrets, configMaps)
+
+			resolved, err := r.ResolveAll(tt.envVars)
+			if err != nil {
+				t.Fatalf("ResolveAll() error = %v", err)
+			}
+
+			got := make(map[string]string, len(resolved))
+			for _, env := range resolved {
+				got[env.Name] = env.Value
+				// Keys imported from a Secret must stay redactable
+				if env.Source == extractor.SourceSecret && env.EnvFrom && !env.IsSecret {
+					t.Errorf("ResolveAll() %s is not marked as secret", env.Name)
+				}
+			}
+			if len(got) != len(tt.expected) {
+				t.Fatalf("ResolveAll() = %v, want %v", got, tt.expected)
+			}
+			for name, want := range tt.expected {
+				if got[name] != want {
+					t.Errorf("ResolveAll() %s = %q, want %q", name, got[name], want)
+				}
+			}
+		})
+	}
+}
+
+func TestResolver_ResolveAll_FallbackToCluster(t *testing.T) {
+	clientset := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "api-secret", Namespace: "default"},
+		Data:       map[string][]byte{"key": []byte("from-cluster")},
+	})
+
+	r := NewFromClientset(clientset, "default")
+	r.AddObjects([]*corev1.Secret{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "db-secret"},
+			StringData: map[string]string{"password": "from-stream"},
+		},
+	}, nil)
+
+	resolved, err := r.ResolveAll([]extractor.EnvVar{
+		{Name: "DB_PASS", Source: extractor.SourceSecret, SecretRef: &extractor.SecretKeyRef{Name: "db-secret", Key: "password"}},
+		{Name: "API_KEY", Source: extractor.SourceSecret, SecretRef: &extractor.SecretKeyRef{Name: "api-secret", Key: "key"}},
+	})
+	if err != nil {
+		t.Fatalf("ResolveAll() error = %v", err)
+	}
+
+	if resolved[0].Value != "from-stream" {
+		t.Errorf("DB_PASS = %q, want %q", resolved[0].Value, "from-stream")
+	}
+	if resolved[1].Value != "from-cluster" {
+		t.Errorf("API_KEY = %q, want %q", resolved[1].Value, "from-cluster")
+	}
+}
+
+func TestResolver_ResolveAll_MissingRefs(t *testing.T) {
+	clientset := fake.NewSimpleClientset(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "app-config", Namespace: "default"},
+		Data:       map[string]string{"log-level": "info"},
+	})
+	r := NewFromClientset(clientset, "default")
+
+	resolved, err := r.ResolveAll([]extractor.EnvVar{
+		{Name: "LOG_LEVEL", Source: extractor.SourceConfigMap, ConfigRef: &extractor.ConfigMapKeyRef{Name: "app-config", Key: "log-level"}},
+		{Name: "OPTIONAL_KEY", Value: "<app-config:missing>", Source: extractor.SourceConfigMap, ConfigRef: &extractor.ConfigMapKeyRef{Name: "app-config", Key: "missing", Optional: true}},
+		{Name: "OPTIONAL_SECRET", Value: "<opt-secret:key>", Source: extractor.SourceSecret, SecretRef: &extractor.SecretKeyRef{Name: "opt-secret", Key: "key", Optional: true}},
+		{Name: "# from secret: opt-secret", Source: extractor.SourceSecret, SecretRef: &extractor.SecretKeyRef{Name: "opt-secret", Key: "*", Optional: true}, EnvFrom: true},
+		{Name: "REQUIRED_KEY", Value: "<app-config:absent>", Source: extractor.SourceConfigMap, ConfigRef: &extractor.ConfigMapKeyRef{Name: "app-config", Key: "absent"}},
+		{Name: "REQUIRED_SECRET", Value: "<db-secret:password>", Source: extractor.SourceSecret, SecretRef: &extractor.SecretKeyRef{Name: "db-secret", Key: "password"}},
+	})
+
+	var missingErr *MissingRefsError
+	if !errors.As(err, &missingErr) {
+		t.Fatalf("ResolveAll() error = %v, want *MissingRefsError", err)
+	}
+	wantRefs := []string{"key absent in configmap default/app-config", "secret default/db-secret"}
+	if strings.Join(missingErr.Refs, ",") != strings.Join(wantRefs, ",") {
+		t.Errorf("MissingRefsError.Refs = %v, want %v", missingErr.Refs, wantRefs)
+	}
+
+	// Optional refs are dropped, required ones keep their placeholder
+	wantNames := []string{"LOG_LEVEL", "REQUIRED_KEY", "REQUIRED_SECRET"}
+	if len(resolved) != len(wantNames) {
+		t.Fatalf("ResolveAll() got %d env vars, want %d", len(resolved), len(wantNames))
+	}
+	for i, name := range wantNames {
+		if resolved[i].Name != name {
+			t.Errorf("ResolveAll()[%d] = %s, want %s", i, resolved[i].Name, name)
+		}
+	}
+	if resolved[2].Value != "<db-secret:password>" {
+		t.Errorf("REQUIRED_SECRET = %q, want placeholder", resolved[2].Value)
+	}
+}
+
+func TestResolver_ResolveAll_Namespaces(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "db-secret", Namespace: "staging"},
+			Data:       map[string][]byte{"password": []byte("staging-pass")},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "db-secret", Namespace: "prod"},
+			Data:       map[string][]byte{"password": []byte("prod-pass")},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "db-secret", Namespace: "default"},
+			Data:       map[string][]byte{"password": []byte("default-pass")},
+		},
+	)
+
+	envVars := []extractor.EnvVar{
+		{Name: "STAGING", Source: extractor.SourceSecret, SecretRef: &extractor.SecretKeyRef{Name: "db-secret", Key: "password"}, Origin: extractor.Origin{Namespace: "staging"}},
+		{Name: "PROD", Source: extractor.SourceSecret, SecretRef: &extractor.SecretKeyRef{Name: "db-secret", Key: "password"}, Origin: extractor.Origin{Namespace: "prod"}},
+		{Name: "UNSET", Source: extractor.SourceSecret, SecretRef: &extractor.SecretKeyRef{Name: "db-secret", Key: "password"}},
+	}
+
+	tests := []struct {
+		name     string
+		resolver *Resolver
+		expected []string
+	}{
+		{
+			name:     "manifest namespaces",
+			resolver: NewFromClientset(clientset, "default"),
+			expected: []string{"staging-pass", "prod-pass", "default-pass"},
+		},
+		{
+			name:     "explicit override",
+			resolver: &Resolver{client: clientset, namespace: "prod", override: "prod"},
+			expected: []string{"prod-pass", "prod-pass", "prod-pass"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolved, err := tt.resolver.ResolveAll(envVars)
+			if err != nil {
+				t.Fatalf("ResolveAll() error = %v", err)
+			}
+			for i, want := range tt.expected {
+				if resolved[i].Value != want {
+					t.Errorf("ResolveAll() %s = %q, want %q", resolved[i].Name, resolved[i].Value, want)
+				}
+			}
+		})
+	}
+}
+
+func TestResolver_AddObjects_Namespaces(t *testing.T) {
+	r := NewOffline("")
+	r.AddObjects([]*corev1.Secret{
+		{ObjectMeta: metav1.ObjectMeta{Name: "db-secret", Namespace: "staging"}, StringData: map[string]string{"password": "staging-pass"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "db-secret"}, StringData: map[string]string{"password": "default-pass"}},
+	}, nil)
+
+	resolved, err := r.ResolveAll([]extractor.EnvVar{
+		{Name: "STAGING", Source: extractor.SourceSecret, SecretRef: &extractor.SecretKeyRef{Name: "db-secret", Key: "password"}, Origin: extractor.Origin{Namespace: "staging"}},
+		{Name: "DEFAULT", Source: extractor.SourceSecret, SecretRef: &extractor.SecretKeyRef{Name: "db-secret", Key: "password"}, Origin: extractor.Origin{Namespace: "default"}},
+	})
+	if err != nil {
+		t.Fatalf("ResolveAll() error = %v", err)
+	}
+	if resolved[0].Value != "staging-pass" || resolved[1].Value != "default-pass" {
+		t.Errorf("ResolveAll() = %q, %q; want staging-pass, default-pass", resolved[0].Value, resolved[1].Value)
+	}
+}
+
+func TestResolver_AddObjects_WithoutNamespace(t *testing.T) {
+	// The bundle's objects omit metadata.namespace while the workload sets it
+	r := NewOffline("")
+	r.AddObjects([]*corev1.Secret{
+		{ObjectMeta: metav1.ObjectMeta{Name: "db-secret"}, StringData: map[string]string{"password": "bundled-pass"}},
+	}, []*corev1.ConfigMap{
+		{ObjectMeta: metav1.ObjectMeta{Name: "app-config"}, Data: map[string]string{"MODE": "fast"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "app-config", Namespace: "staging"}, Data: map[string]string{"MODE": "slow"}},
+	})
+
+	prod := extractor.Origin{Namespace: "prod"}
+	resolved, err := r.ResolveAll([]extractor.EnvVar{
+		{Name: "DB_PASSWORD", Source: extractor.SourceSecret, SecretRef: &extractor.SecretKeyRef{Name: "db-secret", Key: "password"}, Origin: prod},
+		{Name: "MODE", Source: extractor.SourceConfigMap, ConfigRef: &extractor.ConfigMapKeyRef{Name: "app-config", Key: "MODE"}, Origin: prod},
+		{Name: "STAGING_MODE", Source: extractor.SourceConfigMap, ConfigRef: &extractor.ConfigMapKeyRef{Name: "app-config", Key: "MODE"}, Origin: extractor.Origin{Namespace: "staging"}},
+	})
+	if err != nil {
+		t.Fatalf("ResolveAll() error = %v", err)
+	}
+	if resolved[0].Value != "bundled-pass" || resolved[1].Value != "fast" {
+		t.Errorf("ResolveAll() = %q, %q; want the objects without a namespace", resolved[0].Value, resolved[1].Value)
+	}
+	if resolved[2].Value != "slow" {
+		t.Errorf("ResolveAll() STAGING_MODE = %q, want the object in the workload's namespace first", resolved[2].Value)
+	}
+}
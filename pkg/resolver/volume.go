@@ -0,0 +1,239 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// defaultFileMode is the mode of volume files when the spec does not set one
+const defaultFileMode = 0644
+
+// VolumeFile is a file projected into a secret, configMap or projected volume
+type VolumeFile struct {
+	Path string // Relative to the volume root
+	Data []byte
+	Mode os.FileMode
+}
+
+// MountedVolume is a volume mount of a container together with the files of
+// its volume
+type MountedVolume struct {
+	Name      string // Volume name
+	MountPath string
+	SubPath   string
+	IsSecret  bool
+	Files     []VolumeFile
+}
+
+// ResolveVolumes returns the secret, configMap and projected volumes mounted
+// by the container with their files. Other volume types are skipped. Missing
+// optional sources produce empty volumes; missing required sources are
+// reported with a *MissingRefsError.
+func (r *Resolver) ResolveVolumes(pod *corev1.Pod, container *corev1.Container) ([]MountedVolume, error) {
+	ctx := context.Background()
+	namespace := r.namespaceFor(pod.Namespace)
+
+	volumes := make(map[string]corev1.Volume, len(pod.Spec.Volumes))
+	for _, volume := range pod.Spec.Volumes {
+		volumes[volume.Name] = volume
+	}
+
+	var mounted []MountedVolume
+	var missing []string
+	for _, mount := range container.VolumeMounts {
+		volume, ok := volumes[mount.Name]
+		if !ok {
+			continue
+		}
+
+		mv := MountedVolume{
+			Name:      mount.Name,
+			MountPath: mount.MountPath,
+			SubPath:   mount.SubPath,
+		}
+
+		switch {
+		case volume.Secret != nil:
+			files, err := r.secretFiles(ctx, namespace, volume.Secret.SecretName, volume.Secret.Items, volume.Secret.DefaultMode, isOptional(volume.Secret.Optional))
+			if err != nil {
+				missing = appendMissing(missing, err)
+			}
+			mv.Files = files
+			mv.IsSecret = true
+		case volume.ConfigMap != nil:
+			files, err := r.configMapFiles(ctx, namespace, volume.ConfigMap.Name, volume.ConfigMap.Items, volume.ConfigMap.DefaultMode, isOptional(volume.ConfigMap.Optional))
+			if err != nil {
+				missing = appendMissing(missing, err)
+			}
+			mv.Files = files
+		case volume.Projected != nil:
+			for _, source := range volume.Projected.Sources {
+				var files []VolumeFile
+				var err error
+				switch {
+				case source.Secret != nil:
+					files, err = r.secretFiles(ctx, namespace, source.Secret.Name, source.Secret.Items, volume.Projected.DefaultMode, isOptional(source.Secret.Optional))
+					mv.IsSecret = true
+				case source.ConfigMap != nil:
+					files, err = r.configMapFiles(ctx, namespace, source.ConfigMap.Name, source.ConfigMap.Items, volume.Projected.DefaultMode, isOptional(source.ConfigMap.Optional))
+				default:
+					fmt.Fprintf(os.Stderr, "Warning: skipping unsupported projected source in volume %s\n", volume.Name)
+				}
+				if err != nil {
+					missing = appendMissing(missing, err)
+				}
+				mv.Files = append(mv.Files, files...)
+			}
+		default:
+			continue
+		}
+
+		mounted = append(mounted, mv)
+	}
+
+	if len(missing) > 0 {
+		return mounted, &MissingRefsError{Refs: missing}
+	}
+	return mounted, nil
+}
+
+func (r *Resolver) secretFiles(ctx context.Context, namespace, name string, items []corev1.KeyToPath, defaultMode *int32, optional bool) ([]VolumeFile, error) {
+	secret, err := r.getSecret(ctx, namespace, name)
+	if apierrors.IsNotFound(err) || (err == nil && secret == nil && r.client == nil) {
+		if optional {
+			return nil, nil
+		}
+		return nil, &MissingRefsError{Refs: []string{fmt.Sprintf("secret %s/%s", namespace, name)}}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get secret %s/%s: %w", namespace, name, err)
+	}
+
+	return projectFiles(secret.Data, items, defaultMode, optional, fmt.Sprintf("secret %s/%s", namespace, name))
+}
+
+func (r *Resolver) configMapFiles(ctx context.Context, namespace, name string, items []corev1.KeyToPath, defaultMode *int32, optional bool) ([]VolumeFile, error) {
+	configMap, err := r.getConfigMap(ctx, namespace, name)
+	if apierrors.IsNotFound(err) || (err == nil && configMap == nil && r.client == nil) {
+		if optional {
+			return nil, nil
+		}
+		return nil, &MissingRefsError{Refs: []string{fmt.Sprintf("configmap %s/%s", namespace, name)}}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get configmap %s/%s: %w", namespace, name, err)
+	}
+
+	data := make(map[string][]byte)
+	for key, value := range configMapData(configMap) {
+		data[key] = []byte(value)
+	}
+	return projectFiles(data, items, defaultMode, optional, fmt.Sprintf("configmap %s/%s", namespace, name))
+}
+
+// projectFiles lays out data as the kubelet does: every key as a file named
+// after it, or only the listed items at their paths
+func projectFiles(data map[string][]byte, items []corev1.KeyToPath, defaultMode *int32, optional bool, source string) ([]VolumeFile, error) {
+	mode := os.FileMode(defaultFileMode)
+	if defaultMode != nil {
+		mode = os.FileMode(*defaultMode)
+	}
+
+	if len(items) == 0 {
+		keys := make([]string, 0, len(data))
+		for key := range data {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		files := make([]VolumeFile, 0, len(keys))
+		for _, key := range keys {
+			files = append(files, VolumeFile{Path: key, Data: data[key], Mode: mode})
+		}
+		return files, nil
+	}
+
+	var files []VolumeFile
+	var missing []string
+	for _, item := range items {
+		value, ok := data[item.Key]
+		if !ok {
+			if !optional {
+				missing = append(missing, fmt.Sprintf("key %s in %s", item.Key, source))
+			}
+			continue
+		}
+
+		file := VolumeFile{Path: item.Path, Data: value, Mode: mode}
+		if item.Mode != nil {
+			file.Mode = os.FileMode(*item.Mode)
+		}
+		files = append(files, file)
+	}
+
+	if len(missing) > 0 {
+		return files, &MissingRefsError{Refs: missing}
+	}
+	return files, nil
+}
+
+// Write writes the files of the volume below dir/<volume name> and returns
+// the host path to mount at MountPath, taking SubPath into account
+func (v MountedVolume) Write(dir string) (string, error) {
+	root := filepath.Join(dir, v.Name)
+	if err := os.MkdirAll(root, 0700); err != nil {
+		return "", fmt.Errorf("failed to create volume directory: %w", err)
+	}
+
+	for _, file := range v.Files {
+		path, err := containedPath(root, file.Path)
+		if err != nil {
+			return "", err
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return "", fmt.Errorf("failed to create volume directory: %w", err)
+		}
+		if err := os.WriteFile(path, file.Data, file.Mode.Perm()); err != nil {
+			return "", fmt.Errorf("failed to write volume file: %w", err)
+		}
+		// WriteFile does not change the mode of existing files
+		if err := os.Chmod(path, file.Mode.Perm()); err != nil {
+			return "", fmt.Errorf("failed to write volume file: %w", err)
+		}
+	}
+
+	if v.SubPath == "" {
+		return root, nil
+	}
+	return containedPath(root, v.SubPath)
+}
+
+// containedPath joins root and a relative path, rejecting paths that escape root
+func containedPath(root, path string) (string, error) {
+	joined := filepath.Join(root, path)
+	if joined != root && !strings.HasPrefix(joined, root+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid volume path %s", path)
+	}
+	return joined, nil
+}
+
+// appendMissing adds the refs of a *MissingRefsError to missing and reports
+// any other error as a warning
+func appendMissing(missing []string, err error) []string {
+	if missingErr, ok := err.(*MissingRefsError); ok {
+		return append(missing, missingErr.Refs...)
+	}
+	fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	return missing
+}
+
+func isOptional(optional *bool) bool {
+	return optional != nil && *optional
+}
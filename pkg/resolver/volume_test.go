@@ -0,0 +1,104 @@
+package resolver
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestResolver_ResolveVolumes(t *testing.T) {
+	mode := int32(0600)
+
+	r := NewOffline("")
+	r.AddObjects([]*corev1.Secret{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "tls"},
+			Data:       map[string][]byte{"tls.crt": []byte("cert"), "tls.key": []byte("key")},
+		},
+	}, []*corev1.ConfigMap{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "app-config"},
+			Data:       map[string]string{"app.yaml": "port: 8080", "unused": "x"},
+		},
+	})
+
+	pod := &corev1.Pod{
+		Spec: corev1.PodSpec{
+			Volumes: []corev1.Volume{
+				{Name: "tls", VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: "tls", DefaultMode: &mode}}},
+				{Name: "config", VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "app-config"},
+					Items:                []corev1.KeyToPath{{Key: "app.yaml", Path: "conf/app.yaml"}},
+				}}},
+				{Name: "bundle", VolumeSource: corev1.VolumeSource{Projected: &corev1.ProjectedVolumeSource{
+					Sources: []corev1.VolumeProjection{
+						{Secret: &corev1.SecretProjection{LocalObjectReference: corev1.LocalObjectReference{Name: "tls"}, Items: []corev1.KeyToPath{{Key: "tls.crt", Path: "ca.crt"}}}},
+						{ConfigMap: &corev1.ConfigMapProjection{LocalObjectReference: corev1.LocalObjectReference{Name: "app-config"}, Items: []corev1.KeyToPath{{Key: "app.yaml", Path: "app.yaml"}}}},
+					},
+				}}},
+				{Name: "cache", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+			},
+		},
+	}
+	container := &corev1.Container{
+		Name: "app",
+		VolumeMounts: []corev1.VolumeMount{
+			{Name: "tls", MountPath: "/etc/tls"},
+			{Name: "config", MountPath: "/etc/app/app.yaml", SubPath: "conf/app.yaml"},
+			{Name: "bundle", MountPath: "/etc/bundle"},
+			{Name: "cache", MountPath: "/cache"},
+		},
+	}
+
+	volumes, err := r.ResolveVolumes(pod, container)
+	if err != nil {
+		t.Fatalf("ResolveVolumes() error = %v", err)
+	}
+	if len(volumes) != 3 {
+		t.Fatalf("ResolveVolumes() got %d volumes, want 3", len(volumes))
+	}
+
+	dir := t.TempDir()
+	expected := []struct {
+		hostPath string
+		files    map[string]string
+	}{
+		{hostPath: "tls", files: map[string]string{"tls/tls.crt": "cert", "tls/tls.key": "key"}},
+		{hostPath: "config/conf/app.yaml", files: map[string]string{"config/conf/app.yaml": "port: 8080"}},
+		{hostPath: "bundle", files: map[string]string{"bundle/ca.crt": "cert", "bundle/app.yaml": "port: 8080"}},
+	}
+
+	for i, want := range expected {
+		hostPath, err := volumes[i].Write(dir)
+		if err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+		if hostPath != filepath.Join(dir, want.hostPath) {
+			t.Errorf("Write() = %s, want %s", hostPath, filepath.Join(dir, want.hostPath))
+		}
+		for path, content := range want.files {
+			data, err := os.ReadFile(filepath.Join(dir, path))
+			if err != nil {
+				t.Errorf("ReadFile(%s) error = %v", path, err)
+				continue
+			}
+			if string(data) != content {
+				t.Errorf("%s = %q, want %q", path, data, content)
+			}
+		}
+	}
+
+	info, err := os.Stat(filepath.Join(dir, "tls", "tls.key"))
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("tls.key mode = %v, want 0600", info.Mode().Perm())
+	}
+	if _, err := os.Stat(filepath.Join(dir, "config", "unused")); !os.IsNotExist(err) {
+		t.Errorf("unlisted key was written")
+	}
+}